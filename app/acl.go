@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ACL subcommands
+const (
+	ACL_SETUSER = "SETUSER"
+	ACL_GETUSER = "GETUSER"
+	ACL_DELUSER = "DELUSER"
+	ACL_LIST    = "LIST"
+	ACL_USERS   = "USERS"
+	ACL_WHOAMI  = "WHOAMI"
+	ACL_CAT     = "CAT"
+	ACL_LOG     = "LOG"
+	ACL_SAVE    = "SAVE"
+	ACL_LOAD    = "LOAD"
+)
+
+const NOPERM = "NOPERM"
+
+// aclCategories are the command categories known to ACL rules, reported by ACL CAT
+var aclCategories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap", "hyperloglog",
+	"geo", "stream", "pubsub", "admin", "fast", "slow", "blocking", "dangerous", "connection",
+	"transaction", "scripting",
+}
+
+// aclContainerCommands have subcommands which can be allowed or denied on their own, as in +config|get
+var aclContainerCommands = []string{ACL, CLIENT, COMMAND, CONFIG, LATENCY, SLOWLOG}
+
+func init() {
+	// registered here since ACL SETUSER checks command names against RespCommands
+	RespCommands[ACL] = Acl
+}
+
+var ErrACLFileNotConfigured = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+var ErrNoKeyPermission = errors.New("No permissions to access a key")
+
+// keyPattern is a key glob pattern along with the kind of access it grants
+type keyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+func (k keyPattern) String() string {
+	switch {
+	case k.read && k.write:
+		return "~" + k.pattern
+	case k.read:
+		return "%R~" + k.pattern
+	default:
+		return "%W~" + k.pattern
+	}
+}
+
+// User is an ACL user. Users are never modified once stored in the ACL, ACL SETUSER replaces them
+// with a modified copy, so they can be read without locking.
+type User struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// SHA-256 hashes of the passwords, hex encoded
+	passwords []string
+	// command rules in the order they were applied, such as +@all, -@dangerous or +config|get
+	commandRules    []string
+	keyPatterns     []keyPattern
+	channelPatterns []string
+}
+
+// newUser creates a user which is disabled and cannot run any command
+func newUser(name string) *User {
+	return &User{name: name, commandRules: []string{"-@all"}}
+}
+
+// newDefaultUser creates the default user, which can run every command without a password
+func newDefaultUser() *User {
+	return &User{
+		name:            DEFAULT_USER,
+		enabled:         true,
+		nopass:          true,
+		commandRules:    []string{"+@all"},
+		keyPatterns:     []keyPattern{{"*", true, true}},
+		channelPatterns: []string{"*"},
+	}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commandRules = slices.Clone(u.commandRules)
+	c.keyPatterns = slices.Clone(u.keyPatterns)
+	c.channelPatterns = slices.Clone(u.channelPatterns)
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range hash {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRule changes the user according to a single ACL rule, such as on, >password or +@read
+func (u *User) applyRule(rule string) error {
+	lowerRule := strings.ToLower(rule)
+	switch lowerRule {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keyPatterns = []keyPattern{{"*", true, true}}
+	case "resetkeys":
+		u.keyPatterns = nil
+	case "allchannels":
+		u.channelPatterns = []string{"*"}
+	case "resetchannels":
+		u.channelPatterns = nil
+	case "allcommands":
+		return u.applyRule("+@all")
+	case "nocommands":
+		return u.applyRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.applyRule(r)
+		}
+	default:
+		return u.applyPrefixedRule(rule)
+	}
+	return nil
+}
+
+func (u *User) applyPrefixedRule(rule string) error {
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+
+	switch rule[0] {
+	case '>':
+		hash := hashPassword(rule[1:])
+		if !slices.Contains(u.passwords, hash) {
+			u.passwords = append(u.passwords, hash)
+		}
+		u.nopass = false
+	case '#':
+		hash := rule[1:]
+		if !isPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		if !slices.Contains(u.passwords, hash) {
+			u.passwords = append(u.passwords, hash)
+		}
+		u.nopass = false
+	case '<', '!':
+		hash := rule[1:]
+		if rule[0] == '<' {
+			hash = hashPassword(hash)
+		} else if !isPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		index := slices.Index(u.passwords, hash)
+		if index == -1 {
+			return errors.New("The password you are trying to remove from the user does not exist")
+		}
+		u.passwords = slices.Delete(u.passwords, index, index+1)
+	case '~', '%':
+		return u.addKeyPattern(rule)
+	case '&':
+		if slices.Contains(u.channelPatterns, "*") {
+			return errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+		}
+		u.channelPatterns = append(u.channelPatterns, rule[1:])
+	case '+', '-':
+		return u.addCommandRule(rule)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// addKeyPattern handles ~pattern, which grants read and write access, and %R~, %W~ and %RW~ patterns
+func (u *User) addKeyPattern(rule string) error {
+	pattern := keyPattern{read: true, write: true}
+	if rule[0] == '%' {
+		flags, glob, found := strings.Cut(rule[1:], "~")
+		if !found || flags == "" {
+			return errors.New("Syntax error")
+		}
+		pattern.read, pattern.write = false, false
+		for _, flag := range strings.ToUpper(flags) {
+			switch flag {
+			case 'R':
+				pattern.read = true
+			case 'W':
+				pattern.write = true
+			default:
+				return errors.New("Syntax error")
+			}
+		}
+		pattern.pattern = glob
+	} else {
+		pattern.pattern = rule[1:]
+	}
+
+	for _, existing := range u.keyPatterns {
+		if existing.pattern == "*" && existing.read && existing.write {
+			return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+		}
+	}
+	u.keyPatterns = append(u.keyPatterns, pattern)
+	return nil
+}
+
+// addCommandRule handles +command, -command, +command|subcommand, +@category and -@category
+func (u *User) addCommandRule(rule string) error {
+	target := strings.ToLower(rule[1:])
+	if category, isCategory := strings.CutPrefix(target, "@"); isCategory {
+		if category != "all" && !slices.Contains(aclCategories, category) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+	} else {
+		command, subcommand, hasSubcommand := strings.Cut(target, "|")
+		if _, exists := RespCommands[strings.ToUpper(command)]; !exists {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		if hasSubcommand && (subcommand == "" || !slices.Contains(aclContainerCommands, strings.ToUpper(command))) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+	}
+
+	rule = rule[:1] + target
+	if target == "@all" {
+		u.commandRules = []string{rule}
+		return nil
+	}
+	// a later rule on the same target overrides the earlier one, which is dropped
+	u.commandRules = slices.DeleteFunc(u.commandRules, func(r string) bool { return r[1:] == target })
+	u.commandRules = append(u.commandRules, rule)
+	return nil
+}
+
+// CanRunCommand reports whether the user may run the command. Rules are applied in order, so the
+// last rule matching the command decides.
+func (u *User) CanRunCommand(command string, subcommand string, categories []string) bool {
+	allowed := false
+	for _, rule := range u.commandRules {
+		grant, target := rule[0] == '+', rule[1:]
+		switch {
+		case target == "@all":
+			allowed = grant
+		case strings.HasPrefix(target, "@"):
+			if slices.Contains(categories, target) {
+				allowed = grant
+			}
+		case target == command:
+			allowed = grant
+		case subcommand != "" && target == command+"|"+subcommand:
+			allowed = grant
+		}
+	}
+	return allowed
+}
+
+// CanAccessKey reports whether the user may read, or write if write is set, the key
+func (u *User) CanAccessKey(key string, write bool) bool {
+	for _, pattern := range u.keyPatterns {
+		if (write && !pattern.write) || (!write && !pattern.read) {
+			continue
+		}
+		if GlobMatch(pattern.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPassword reports whether password authenticates the user
+func (u *User) CheckPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	return u.nopass || slices.Contains(u.passwords, hashPassword(password))
+}
+
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *User) keysDescription() string {
+	patterns := []string{}
+	for _, pattern := range u.keyPatterns {
+		patterns = append(patterns, pattern.String())
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *User) channelsDescription() string {
+	patterns := []string{}
+	for _, pattern := range u.channelPatterns {
+		patterns = append(patterns, "&"+pattern)
+	}
+	return strings.Join(patterns, " ")
+}
+
+// Describe returns the rules recreating the user, in the format of ACL LIST and the ACL file
+func (u *User) Describe() string {
+	parts := append([]string{"user", u.name}, u.flags()...)
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.keysDescription(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.channelsDescription(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.commandRules...)
+	return strings.Join(parts, " ")
+}
+
+// ACLStore holds every ACL user
+type ACLStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+var ServerACL = &ACLStore{users: map[string]*User{DEFAULT_USER: newDefaultUser()}}
+
+// User returns the user with the given name, or nil if it does not exist
+func (a *ACLStore) User(name string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// Users returns every user, sorted by name
+func (a *ACLStore) Users() []*User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	users := []*User{}
+	for _, user := range a.users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(x, y *User) int { return strings.Compare(x.name, y.name) })
+	return users
+}
+
+// SetUser applies the rules to the user, creating it if it does not exist. Either every rule is
+// applied or the user is left untouched.
+func (a *ACLStore) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := newUser(name)
+	if existing, exists := a.users[name]; exists {
+		user = existing.clone()
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+// DeleteUsers removes the users and returns how many existed
+func (a *ACLStore) DeleteUsers(names []string) (int, error) {
+	if slices.Contains(names, DEFAULT_USER) {
+		return 0, errors.New("The 'default' user cannot be removed")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	deleted := 0
+	for _, name := range names {
+		if _, exists := a.users[name]; exists {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Authenticate reports whether the username and password pair is valid
+func (a *ACLStore) Authenticate(username, password string) bool {
+	user := a.User(username)
+	return user != nil && user.CheckPassword(password)
+}
+
+// AutoAuthenticates reports whether new connections are authenticated as the default user, which
+// happens when the default user is enabled and needs no password
+func (a *ACLStore) AutoAuthenticates() bool {
+	user := a.User(DEFAULT_USER)
+	return user.enabled && user.nopass
+}
+
+// SetDefaultUserPassword puts requirepass into effect: the default user needs password, or no
+// password at all when it is empty
+func (a *ACLStore) SetDefaultUserPassword(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := a.users[DEFAULT_USER].clone()
+	user.nopass = password == ""
+	user.passwords = nil
+	if password != "" {
+		user.passwords = []string{hashPassword(password)}
+	}
+	a.users[DEFAULT_USER] = user
+}
+
+func applyRequirePass(value string) error {
+	ServerACL.SetDefaultUserPassword(value)
+	return nil
+}
+
+// LoadACL sets up the users on startup, from requirepass and then from the ACL file if there is one
+func LoadACL() error {
+	ServerACL.SetDefaultUserPassword(ServerConfig.Get(CONFIG_REQUIREPASS))
+	if ServerConfig.Get(CONFIG_ACLFILE) == "" {
+		return nil
+	}
+	return ServerACL.LoadFile(ServerConfig.Get(CONFIG_ACLFILE))
+}
+
+// LoadFile replaces every user with the ones defined in the ACL file. Nothing changes if the file has
+// an error. The default user is kept as it is if the file does not define it.
+func (a *ACLStore) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %v", path, err)
+	}
+	defer file.Close()
+
+	loaded := &ACLStore{users: map[string]*User{}}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: line should start with user keyword", path, lineNumber)
+		}
+		if _, exists := loaded.users[fields[1]]; exists {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNumber, fields[1])
+		}
+		if err := loaded.SetUser(fields[1], fields[2:]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := loaded.users[DEFAULT_USER]; !exists {
+		loaded.users[DEFAULT_USER] = a.users[DEFAULT_USER]
+	}
+	a.users = loaded.users
+	return nil
+}
+
+// SaveFile writes every user to the ACL file, replacing it atomically
+func (a *ACLStore) SaveFile(path string) error {
+	lines := []string{}
+	for _, user := range a.Users() {
+		lines = append(lines, user.Describe())
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "temp-acl-*.acl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.WriteString(strings.Join(lines, "\n") + "\n")
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// disconnectRemovedUsers closes the connections authenticated as users which no longer exist
+func disconnectRemovedUsers() {
+	for _, client := range ServerClients.List() {
+		client.mu.Lock()
+		user := client.user
+		client.mu.Unlock()
+		if ServerACL.User(user) == nil {
+			client.Close()
+		}
+	}
+}
+
+// aclCommandName returns the name ACL rules use for the command, such as get or config|set
+func aclCommandName(command string, args []string) (string, string) {
+	name := strings.ToLower(command)
+	if slices.Contains(aclContainerCommands, command) && len(args) > 0 {
+		return name, strings.ToLower(args[0])
+	}
+	return name, ""
+}
+
+// checkCommandPermissions returns the error reply for a command the user of client may not run, or
+// an empty string if it is allowed. Write commands need write access to their keys, every other
+// command read access. Denials are recorded in the ACL log.
+func checkCommandPermissions(client *Client, respCommand RespCommand, cmp CommandComponents) string {
+	client.mu.Lock()
+	username := client.user
+	client.mu.Unlock()
+	user := ServerACL.User(username)
+
+	command, subcommand := aclCommandName(cmp.Command, cmp.Args)
+	fullName := command
+	if subcommand != "" {
+		fullName += "|" + subcommand
+	}
+	if user == nil || !user.CanRunCommand(command, subcommand, respCommand.categories) {
+		ServerACLLog.Add(ACL_LOG_REASON_COMMAND, fullName, username, client)
+		err := fmt.Errorf("User %s has no permissions to run the '%s' command", username, fullName)
+		return ToRespErrorWithCode(NOPERM, err)
+	}
+
+	write := slices.Contains(respCommand.flags, "write")
+	for _, position := range respCommand.KeyPositions(cmp.Args) {
+		key := cmp.Args[position-1]
+		if !user.CanAccessKey(key, write) {
+			ServerACLLog.Add(ACL_LOG_REASON_KEY, key, username, client)
+			return ToRespErrorWithCode(NOPERM, ErrNoKeyPermission)
+		}
+	}
+	return ""
+}
+
+// getUserReply describes the user in the format of ACL GETUSER
+func getUserReply(user *User) string {
+	flags := []string{}
+	for _, flag := range user.flags() {
+		flags = append(flags, ToRespBulkString(flag))
+	}
+	passwords := []string{}
+	for _, hash := range user.passwords {
+		passwords = append(passwords, ToRespBulkString(hash))
+	}
+	return ConcatIntoRespArray([]string{
+		ToRespBulkString("flags"), ConcatIntoRespArray(flags),
+		ToRespBulkString("passwords"), ConcatIntoRespArray(passwords),
+		ToRespBulkString("commands"), ToRespBulkString(strings.Join(user.commandRules, " ")),
+		ToRespBulkString("keys"), ToRespBulkString(user.keysDescription()),
+		ToRespBulkString("channels"), ToRespBulkString(user.channelsDescription()),
+		ToRespBulkString("selectors"), ConcatIntoRespArray([]string{}),
+	})
+}
+
+// ExecuteACL runs the ACL subcommands on behalf of client
+func ExecuteACL(args []string, client *Client) (string, error) {
+	subcommandArg, subcommand := args[0], strings.ToUpper(args[0])
+	args = args[1:]
+
+	switch subcommand {
+	case ACL_SETUSER:
+		if len(args) < 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'acl|setuser' command")), nil
+		}
+		if strings.ContainsAny(args[0], " \t\r\n") {
+			return ToRespError(errors.New("Usernames can't contain spaces or null characters")), nil
+		}
+		if err := ServerACL.SetUser(args[0], args[1:]); err != nil {
+			return ToRespError(err), nil
+		}
+		return ToRespSimpleString(OK), nil
+	case ACL_GETUSER:
+		if len(args) != 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'acl|getuser' command")), nil
+		}
+		user := ServerACL.User(args[0])
+		if user == nil {
+			return NULL_BULK_STRING, nil
+		}
+		return getUserReply(user), nil
+	case ACL_DELUSER:
+		if len(args) < 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'acl|deluser' command")), nil
+		}
+		deleted, err := ServerACL.DeleteUsers(args)
+		if err != nil {
+			return ToRespError(err), nil
+		}
+		disconnectRemovedUsers()
+		return ToRespInteger(deleted), nil
+	case ACL_LIST, ACL_USERS:
+		items := []string{}
+		for _, user := range ServerACL.Users() {
+			if subcommand == ACL_LIST {
+				items = append(items, ToRespBulkString(user.Describe()))
+			} else {
+				items = append(items, ToRespBulkString(user.name))
+			}
+		}
+		return ConcatIntoRespArray(items), nil
+	case ACL_WHOAMI:
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return ToRespBulkString(client.user), nil
+	case ACL_CAT:
+		return aclCat(args)
+	case ACL_LOG:
+		return ServerACLLog.Execute(args)
+	case ACL_SAVE, ACL_LOAD:
+		path := ServerConfig.Get(CONFIG_ACLFILE)
+		if path == "" {
+			return ToRespError(ErrACLFileNotConfigured), nil
+		}
+		var err error
+		if subcommand == ACL_SAVE {
+			err = ServerACL.SaveFile(path)
+		} else if err = ServerACL.LoadFile(path); err == nil {
+			disconnectRemovedUsers()
+		}
+		if err != nil {
+			return ToRespError(err), nil
+		}
+		return ToRespSimpleString(OK), nil
+	default:
+		return ToRespError(fmt.Errorf("unknown subcommand '%s'. Try ACL HELP.", subcommandArg)), nil
+	}
+}
+
+// aclCat lists the categories, or the commands in a category
+func aclCat(args []string) (string, error) {
+	if len(args) > 1 {
+		return ToRespError(errors.New("wrong number of arguments for 'acl|cat' command")), nil
+	}
+
+	items := []string{}
+	if len(args) == 0 {
+		for _, category := range aclCategories {
+			items = append(items, ToRespBulkString(category))
+		}
+		return ConcatIntoRespArray(items), nil
+	}
+
+	category := strings.ToLower(args[0])
+	if !slices.Contains(aclCategories, category) {
+		return ToRespError(fmt.Errorf("Unknown category '%s'", args[0])), nil
+	}
+	for _, name := range SortedCommandNames() {
+		if slices.Contains(RespCommands[strings.ToUpper(name)].categories, "@"+category) {
+			items = append(items, ToRespBulkString(name))
+		}
+	}
+	return ConcatIntoRespArray(items), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons of ACL log entries
+const (
+	ACL_LOG_REASON_AUTH    = "auth"
+	ACL_LOG_REASON_COMMAND = "command"
+	ACL_LOG_REASON_KEY     = "key"
+)
+
+// denials repeated within this window are merged into the previous entry
+const ACL_LOG_GROUPING_WINDOW = 60 * time.Second
+
+type aclLogEntry struct {
+	id         int64
+	count      int64
+	reason     string
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+// ACLLog records the commands and authentications denied by the ACL, newest first
+type ACLLog struct {
+	mu      sync.Mutex
+	entries []*aclLogEntry
+	nextId  int64
+}
+
+var ServerACLLog = &ACLLog{}
+
+// Add records a denial. A denial matching a recent entry only increases its count.
+func (l *ACLLog) Add(reason, object, username string, client *Client) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if entry.reason == reason && entry.object == object && entry.username == username &&
+			now.Sub(entry.updated) < ACL_LOG_GROUPING_WINDOW {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = client.Info()
+			return
+		}
+	}
+
+	entry := &aclLogEntry{
+		id:         l.nextId,
+		count:      1,
+		reason:     reason,
+		object:     object,
+		username:   username,
+		clientInfo: client.Info(),
+		created:    now,
+		updated:    now,
+	}
+	l.nextId++
+	l.entries = append([]*aclLogEntry{entry}, l.entries...)
+	if maxLen := ServerConfig.GetInt(CONFIG_ACLLOG_MAX_LEN); len(l.entries) > maxLen {
+		l.entries = l.entries[:max(maxLen, 0)]
+	}
+}
+
+func (l *ACLLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// Execute runs ACL LOG [count | RESET]
+func (l *ACLLog) Execute(args []string) (string, error) {
+	if len(args) > 1 {
+		return ToRespError(errors.New("wrong number of arguments for 'acl|log' command")), nil
+	}
+	count := 10
+	if len(args) == 1 {
+		if strings.ToUpper(args[0]) == "RESET" {
+			l.Reset()
+			return ToRespSimpleString(OK), nil
+		}
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 0 {
+			return ToRespError(errors.New("value is out of range, must be positive")), nil
+		}
+		count = parsed
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := []string{}
+	for _, entry := range l.entries[:min(count, len(l.entries))] {
+		items = append(items, ConcatIntoRespArray([]string{
+			ToRespBulkString("count"), ToRespInteger(int(entry.count)),
+			ToRespBulkString("reason"), ToRespBulkString(entry.reason),
+			ToRespBulkString("context"), ToRespBulkString("toplevel"),
+			ToRespBulkString("object"), ToRespBulkString(entry.object),
+			ToRespBulkString("username"), ToRespBulkString(entry.username),
+			ToRespBulkString("age-seconds"), ToRespBulkString(strconv.FormatFloat(now.Sub(entry.created).Seconds(), 'f', 3, 64)),
+			ToRespBulkString("client-info"), ToRespBulkString(entry.clientInfo),
+			ToRespBulkString("entry-id"), ToRespInteger(int(entry.id)),
+			ToRespBulkString("timestamp-created"), ToRespInteger(int(entry.created.UnixMilli())),
+			ToRespBulkString("timestamp-last-updated"), ToRespInteger(int(entry.updated.UnixMilli())),
+		}))
+	}
+	return ConcatIntoRespArray(items), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"time"
+)
+
+// Active expire cycle tuning, following Redis
+const (
+	ACTIVE_EXPIRE_CYCLE_INTERVAL = 100 * time.Millisecond
+	// keys with an expiry sampled from a database at a time
+	ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP = 20
+	// a database is sampled again while more than this percentage of the sampled keys had expired
+	ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE = 25
+	// a cycle stops once it ran for this long, and the next one resumes from the database it stopped at
+	ACTIVE_EXPIRE_CYCLE_TIME_LIMIT = ACTIVE_EXPIRE_CYCLE_INTERVAL / 4
+)
+
+// activeExpirer removes the keys which expired without being accessed again. It is only used by the
+// goroutine running the cycles.
+type activeExpirer struct {
+	server RedisServer
+	// database the next cycle starts from
+	nextDB int
+}
+
+// runActiveExpire runs an active expire cycle every ACTIVE_EXPIRE_CYCLE_INTERVAL, on the command
+// executor in the single executor mode. Only masters run it: replicas keep expired keys, which their
+// readers find missing, until the master propagates their DEL, so both hold the same keys at every
+// point of the replication stream.
+func runActiveExpire(server RedisServer) {
+	expirer := &activeExpirer{server: server}
+	ticker := time.NewTicker(ACTIVE_EXPIRE_CYCLE_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.GetStatus().Lifecycle.ShuttingDown() {
+			continue
+		}
+		RunOnExecutor(expirer.cycle)
+	}
+}
+
+// cycle samples the keys with an expiry of every database, removing the expired ones, and samples a
+// database again as long as many of its sampled keys had expired
+func (e *activeExpirer) cycle() {
+	start := time.Now()
+	sampled, expired := 0, 0
+	timeCapReached := false
+	dbs := ServerDatabases.All()
+	for i := 0; i < len(dbs) && !timeCapReached; i++ {
+		index := (e.nextDB + i) % len(dbs)
+		for {
+			// the DEL is queued before the key is unlocked, so it cannot follow a later write of the key
+			loopSampled, loopExpired := dbs[index].ExpireSample(ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP, func(key string) {
+				Stats.ExpiredKeys.Add(1)
+				e.server.PropagateExpired(index, key)
+			})
+			if loopExpired > 0 {
+				ServerPropagation.Send()
+			}
+			sampled += loopSampled
+			expired += loopExpired
+			if loopSampled == 0 || loopExpired*100 <= loopSampled*ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE {
+				break
+			}
+			if time.Since(start) > ACTIVE_EXPIRE_CYCLE_TIME_LIMIT {
+				timeCapReached = true
+				e.nextDB = index
+				break
+			}
+		}
+	}
+
+	Stats.RecordExpireCycle(sampled, expired, timeCapReached)
+	ServerLatencyMonitor.SampleSince(LATENCY_EVENT_EXPIRE_CYCLE, start)
+}
+
+// expireCommandKeys removes the keys the command is about to access which have expired, and
+// queues the propagation of their removal while the key is still locked, so the command finds them
+// missing like any other key and no write of another client is propagated ahead of the DEL. Replicas
+// leave the removal to their master.
+func expireCommandKeys(server RedisServer, client *Client, cmp CommandComponents) {
+	if _, ok := server.(*RedisMasterServer); !ok {
+		return
+	}
+	respCommand := RespCommands[cmp.Command]
+	positions := respCommand.KeyPositions(cmp.Args)
+	if len(positions) == 0 {
+		return
+	}
+
+	index := client.DB()
+	db := ServerDatabases.DB(index)
+	expired := false
+	for _, position := range positions {
+		key := cmp.Args[position-1]
+		expired = db.DeleteIfExpired(key, func() {
+			Stats.ExpiredKeys.Add(1)
+			server.PropagateExpired(index, key)
+		}) || expired
+	}
+	if expired {
+		ServerPropagation.Send()
+	}
+}
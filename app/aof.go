@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Append only file defaults and fsync policies
+const (
+	AOF_DEFAULT_FILENAME = "appendonly.aof"
+	AOF_FSYNC_ALWAYS     = "always"
+	AOF_FSYNC_EVERYSEC   = "everysec"
+	AOF_FSYNC_NO         = "no"
+)
+
+// AppendOnlyFile logs every write command, so the dataset can be rebuilt by replaying it on startup
+type AppendOnlyFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	stop   chan struct{}
+	// database selected by the logged commands, -1 until the first write selects one
+	selectedDB int
+}
+
+var (
+	// appendOnly is nil while the append only file is disabled
+	appendOnly   *AppendOnlyFile
+	appendOnlyMu sync.Mutex
+)
+
+func GetAppendOnlyFilePath() string {
+	return filepath.Join(ServerConfig.Get(CONFIG_DIR), ServerConfig.Get(CONFIG_AOF_NAME))
+}
+
+// StartAppendOnly enables the append only file at runtime. The file is first rewritten from the
+// current dataset, since commands run before it was enabled were never logged. No write is applied
+// while the snapshot is taken, and the writes queued by then are part of it, so they are sent to the
+// replicas before the file is open. The propagation queue is not sent again until it is, so every
+// later write is fed to the new file.
+func StartAppendOnly() error {
+	dbs := ServerDatabases.All()
+	ServerPropagation.order.Lock()
+	unlock := LockAll(dbs)
+	ServerPropagation.sending.Lock()
+	defer ServerPropagation.sending.Unlock()
+	if AppendOnlyEnabled() {
+		unlock()
+		ServerPropagation.order.Unlock()
+		return nil
+	}
+	snapshot := appendOnlySnapshot(dbs)
+	queued := ServerPropagation.take()
+	unlock()
+	ServerPropagation.order.Unlock()
+	sendPropagatedWrites(queued)
+
+	path := GetAppendOnlyFilePath()
+	err := RewriteAppendOnlyFile(path, snapshot)
+	if err != nil {
+		return err
+	}
+
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	appendOnly, err = openAppendOnlyFile(path)
+	return err
+}
+
+// StopAppendOnly flushes and closes the append only file
+func StopAppendOnly() {
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	if appendOnly == nil {
+		return
+	}
+
+	appendOnly.close()
+	appendOnly = nil
+}
+
+// LoadAppendOnlyFile replays the append only file, if it exists, and keeps appending to it
+func LoadAppendOnlyFile(server RedisServer) error {
+	path := GetAppendOnlyFilePath()
+	err := replayAppendOnlyFile(path, server)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	appendOnly, err = openAppendOnlyFile(path)
+	return err
+}
+
+func AppendOnlyEnabled() bool {
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	return appendOnly != nil
+}
+
+// FeedAppendOnly appends a write command run against the database at db, in its raw RESP form, to the
+// append only file if it is enabled
+func FeedAppendOnly(db int, rawInput string) {
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	if appendOnly == nil {
+		return
+	}
+	appendOnly.feed(appendOnly.selectDB(db) + rawInput)
+}
+
+// FeedAppendOnlyTransaction appends the writes of a transaction between MULTI and EXEC, so they are
+// loaded together
+func FeedAppendOnlyTransaction(writes []TransactionWrite) {
+	appendOnlyMu.Lock()
+	defer appendOnlyMu.Unlock()
+	if appendOnly == nil {
+		return
+	}
+	input := ToRespBulkStringArray(MULTI)
+	for _, write := range writes {
+		input += appendOnly.selectDB(write.db) + write.input
+	}
+	appendOnly.feed(input + ToRespBulkStringArray(EXEC))
+}
+
+// selectDB returns the SELECT to write before a write against the database at db, or nothing when
+// the file already has it selected. appendOnlyMu must be held.
+func (a *AppendOnlyFile) selectDB(db int) string {
+	if db == a.selectedDB {
+		return ""
+	}
+	a.selectedDB = db
+	return ToRespBulkStringArray(SELECT, strconv.Itoa(db))
+}
+
+func (a *AppendOnlyFile) feed(rawInput string) {
+	err := a.write(rawInput)
+	Stats.AofLastWriteFailed.Store(err != nil)
+	if err != nil {
+		fmt.Println("Error writing to the append only file: ", err)
+	}
+}
+
+func openAppendOnlyFile(path string) (*AppendOnlyFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AppendOnlyFile{file: f, writer: bufio.NewWriter(f), stop: make(chan struct{}), selectedDB: -1}
+	go a.fsyncEverySecond()
+	return a, nil
+}
+
+func (a *AppendOnlyFile) write(rawInput string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err := a.writer.WriteString(rawInput)
+	if err != nil {
+		return err
+	}
+	// every write reaches the OS right away, fsync policies only decide when it reaches the disk
+	err = a.writer.Flush()
+	if err != nil {
+		return err
+	}
+	if ServerConfig.Get(CONFIG_AOF_FSYNC) == AOF_FSYNC_ALWAYS {
+		defer ServerLatencyMonitor.SampleSince(LATENCY_EVENT_AOF_FSYNC_ALWAYS, time.Now())
+		return a.file.Sync()
+	}
+	return nil
+}
+
+func (a *AppendOnlyFile) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if ServerConfig.Get(CONFIG_AOF_FSYNC) != AOF_FSYNC_EVERYSEC {
+				continue
+			}
+			a.mu.Lock()
+			start := time.Now()
+			a.file.Sync()
+			ServerLatencyMonitor.SampleSince(LATENCY_EVENT_AOF_FSYNC_EVERYSEC, start)
+			a.mu.Unlock()
+		}
+	}
+}
+
+func (a *AppendOnlyFile) close() {
+	close(a.stop)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writer.Flush()
+	a.file.Sync()
+	a.file.Close()
+}
+
+func replayAppendOnlyFile(path string, server RedisServer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	respReader := NewRESPMessageReader()
+	// commands are replayed by a client which is not registered, so it never shows up in CLIENT LIST
+	client := newClient(nil, CLIENT_TYPE_NORMAL)
+	commands := 0
+
+	for {
+		message, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		ready, err := respReader.Read(message)
+		if err != nil {
+			return fmt.Errorf("bad command in append only file after %d commands: %v", commands, err)
+		}
+		if !ready {
+			continue
+		}
+
+		cmp := respReader.GetCommandComponents()
+		if !IsRESPCommandSupported(cmp.Command) {
+			return fmt.Errorf("bad command in append only file after %d commands: %v", commands, unknownCommandError(cmp))
+		}
+		RespCommands[cmp.Command].Execute(cmp.Args, server, client)
+		respReader.Reset()
+		commands++
+	}
+
+	fmt.Printf("Loaded %d commands from the append only file %s\n", commands, path)
+	return nil
+}
+
+// appendOnlySnapshot returns the commands that rebuild the databases, which must be locked by LockAll
+func appendOnlySnapshot(dbs []*ServerMemory) []byte {
+	var snapshot bytes.Buffer
+	now := time.Now().UnixMilli()
+	for index, db := range dbs {
+		selected := false
+		db.forEachLocked(func(key string, memItem MemoryItem) {
+			if memItem.expires != 0 && memItem.expires <= now {
+				return
+			}
+			if !selected {
+				snapshot.WriteString(ToRespBulkStringArray(SELECT, strconv.Itoa(index)))
+				selected = true
+			}
+			writeSnapshotCommands(&snapshot, key, memItem)
+		})
+	}
+	return snapshot.Bytes()
+}
+
+// writeSnapshotCommands writes the commands that rebuild the item at key
+func writeSnapshotCommands(snapshot *bytes.Buffer, key string, memItem MemoryItem) {
+	value, valueType := memItem.GetValueDirectly()
+	switch valueType {
+	case STRING, INT:
+		var stringValue string
+		if valueType == STRING {
+			stringValue = string(*(value.(*StringValue)))
+		} else {
+			stringValue = fmt.Sprint(int(*(value.(*IntegerValue))))
+		}
+		snapshot.WriteString(ToRespBulkStringArray(SET, key, stringValue))
+	case STREAM:
+		for _, entry := range *(value.(*StreamValue)) {
+			args := []string{XADD, key, entry.id}
+			for field, fieldValue := range entry.values {
+				args = append(args, field, fieldValue.(string))
+			}
+			snapshot.WriteString(ToRespBulkStringArray(args...))
+		}
+	}
+	// expiries are absolute, so keys expire at the same time however long the commands wait to be replayed
+	if memItem.expires != 0 {
+		snapshot.WriteString(ToRespBulkStringArray(PEXPIREAT, key, strconv.FormatInt(memItem.expires, 10)))
+	}
+}
+
+// RewriteAppendOnlyFile replaces the append only file with a snapshot of the dataset
+func RewriteAppendOnlyFile(path string, snapshot []byte) error {
+	tempPath := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(snapshot)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Error codes of authentication failures
+const (
+	NOAUTH    = "NOAUTH"
+	WRONGPASS = "WRONGPASS"
+)
+
+// replaces sensitive arguments, such as passwords, in MONITOR and the slow log
+const REDACTED_ARG = "(redacted)"
+
+var ErrNoAuth = errors.New("Authentication required.")
+var ErrWrongPass = errors.New("invalid username-password pair or user is disabled.")
+var ErrNoPasswordConfigured = errors.New("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+
+// requiresAuth reports whether the client must authenticate before running the command
+func requiresAuth(client *Client, respCommand RespCommand) bool {
+	return !client.Authenticated() && !slices.Contains(respCommand.flags, "no_auth")
+}
+
+// ExecuteAuth runs AUTH [username] password. The single argument form authenticates as the default user.
+func ExecuteAuth(args []string, client *Client) (string, error) {
+	username, password := DEFAULT_USER, args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if len(args) > 2 {
+		return ToRespError(errors.New("syntax error")), nil
+	}
+
+	if len(args) == 1 && ServerACL.User(DEFAULT_USER).nopass {
+		return ToRespError(ErrNoPasswordConfigured), nil
+	}
+	if !ServerACL.Authenticate(username, password) {
+		ServerACLLog.Add(ACL_LOG_REASON_AUTH, strings.ToLower(AUTH), username, client)
+		return ToRespErrorWithCode(WRONGPASS, ErrWrongPass), nil
+	}
+
+	client.authenticate(username)
+	return ToRespSimpleString(OK), nil
+}
+
+// redactArgs hides the arguments of commands which carry passwords: every argument of AUTH, the
+// rules of ACL SETUSER, which may set passwords, and the values given by CONFIG SET to sensitive
+// parameters
+func redactArgs(command string, args []string) []string {
+	redacted := slices.Clone(args)
+	switch {
+	case command == AUTH:
+		for i := range redacted {
+			redacted[i] = REDACTED_ARG
+		}
+	case command == ACL && len(args) > 2 && strings.EqualFold(args[0], ACL_SETUSER):
+		for i := 2; i < len(redacted); i++ {
+			redacted[i] = REDACTED_ARG
+		}
+	case command == CONFIG && len(args) > 0 && strings.EqualFold(args[0], CONFIG_SUBCOMMAND_SET):
+		for i := 1; i+1 < len(redacted); i += 2 {
+			if param, exists := ServerConfig.definition(args[i]); exists && param.sensitive {
+				redacted[i+1] = REDACTED_ARG
+			}
+		}
+	default:
+		return args
+	}
+	return redacted
+}
+
+// isAuthError reports whether a reply from the master is an authentication error
+func isAuthError(reply string) bool {
+	return strings.HasPrefix(reply, ERROR_PREFIX+NOAUTH)
+}
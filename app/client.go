@@ -0,0 +1,711 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client types, used by CLIENT LIST and CLIENT KILL
+const (
+	CLIENT_TYPE_NORMAL  = "normal"
+	CLIENT_TYPE_REPLICA = "replica"
+	CLIENT_TYPE_MASTER  = "master"
+	CLIENT_TYPE_PUBSUB  = "pubsub"
+	// older name of the replica type, still accepted by CLIENT KILL and CLIENT LIST
+	CLIENT_TYPE_SLAVE = "slave"
+)
+
+// CLIENT REPLY modes
+const (
+	CLIENT_REPLY_ON   = "ON"
+	CLIENT_REPLY_OFF  = "OFF"
+	CLIENT_REPLY_SKIP = "SKIP"
+)
+
+// CLIENT subcommands
+const (
+	CLIENT_ID       = "ID"
+	CLIENT_LIST     = "LIST"
+	CLIENT_INFO     = "INFO"
+	CLIENT_SETNAME  = "SETNAME"
+	CLIENT_GETNAME  = "GETNAME"
+	CLIENT_KILL     = "KILL"
+	CLIENT_PAUSE    = "PAUSE"
+	CLIENT_UNPAUSE  = "UNPAUSE"
+	CLIENT_NO_EVICT = "NO-EVICT"
+	CLIENT_REPLY    = "REPLY"
+)
+
+// CLIENT PAUSE modes
+const (
+	CLIENT_PAUSE_WRITE = "WRITE"
+	CLIENT_PAUSE_ALL   = "ALL"
+)
+
+const DEFAULT_USER = "default"
+
+var ErrNoSuchClient = errors.New("No such client")
+
+// Client is a connection to the server, either from a regular client, a replica or the master.
+// It wraps the connection to follow the replies of the current command, which are dropped when
+// replies are turned off with CLIENT REPLY.
+type Client struct {
+	net.Conn
+	id        int64
+	createdAt time.Time
+	// Transaction holds the commands queued between MULTI and EXEC
+	Transaction Transaction
+	// unix time in milliseconds of the last command
+	lastInteraction atomic.Int64
+	// number of bytes read from the connection but not yet parsed into a command
+	queryBuffer atomic.Int64
+	blocked     atomic.Bool
+	monitor     atomic.Bool
+	// number of commands queued by MULTI, or -1 outside of a transaction
+	multi atomic.Int64
+	// number of reply bytes handed to Write and not yet accepted by the connection
+	outputPending atomic.Int64
+	// set once the client is being disconnected for breaking its output buffer limit
+	closing atomic.Bool
+	// replaces the current command in the replication stream and the append only file, see
+	// rewriteCommand. Only the goroutine running the commands of the client uses it.
+	rewrittenInput *string
+
+	// mu guards the fields below, which are read by other clients through CLIENT LIST and CLIENT KILL
+	mu          sync.Mutex
+	name        string
+	clientType  string
+	db          int
+	user        string
+	lastCommand string
+	noEvict     bool
+	replyOff    bool
+	// skipReply drops the replies of the current command, skipNextReply those of the next one
+	skipReply     bool
+	skipNextReply bool
+	// clients connecting while requirepass is set must AUTH before running commands
+	authenticated bool
+	// set once the first reply of the current command has been written
+	replied    bool
+	errorReply bool
+	// when the pending replies went above the soft output buffer limit, zero while below it
+	softLimitSince time.Time
+}
+
+// newClient creates a client which is not registered, such as the one replaying the append only file
+func newClient(conn net.Conn, clientType string) *Client {
+	c := &Client{Conn: conn, createdAt: time.Now(), clientType: clientType, user: DEFAULT_USER, authenticated: true}
+	c.lastInteraction.Store(c.createdAt.UnixMilli())
+	c.multi.Store(-1)
+	return c
+}
+
+func (c *Client) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if !c.replied && len(p) > 0 {
+		c.replied = true
+		c.errorReply = p[0] == ERROR[0]
+	}
+	discard := c.replyOff || c.skipReply || c.Conn == nil
+	c.mu.Unlock()
+
+	if discard {
+		return len(p), nil
+	}
+
+	size := int64(len(p))
+	if c.outputLimitReached(c.outputPending.Add(size)) {
+		c.outputPending.Add(-size)
+		c.closeForOutputLimit()
+		return 0, ErrOutputBufferLimit
+	}
+	// a write blocked on a slow reader fails once the client has been above its soft limit for too long
+	deadline := c.softLimitDeadline()
+	if !deadline.IsZero() {
+		c.Conn.SetWriteDeadline(deadline)
+	}
+
+	n, err := c.Conn.Write(p)
+	if c.outputLimitReached(c.outputPending.Add(-size)) || errors.Is(err, os.ErrDeadlineExceeded) {
+		c.closeForOutputLimit()
+	} else if !deadline.IsZero() && c.softLimitDeadline().IsZero() {
+		c.Conn.SetWriteDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// Close closes the connection of the client, which ends its connection loop
+func (c *Client) Close() error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+func (c *Client) Type() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientType
+}
+
+func (c *Client) SetType(clientType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clientType = clientType
+}
+
+// DB returns the index of the database selected by the client
+func (c *Client) DB() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db
+}
+
+func (c *Client) SelectDB(index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.db = index
+}
+
+// Database returns the keyspace selected by the client
+func (c *Client) Database() *ServerMemory {
+	return ServerDatabases.DB(c.DB())
+}
+
+// expired reports whether item has expired for the commands of the client. A replica leaves expiring
+// keys to its master, which sends a DEL once they expire on its own clock, so the commands of the
+// master still find keys which already expired on the clock of the replica, and change them as they
+// did on the master.
+func (c *Client) expired(item MemoryItem) bool {
+	return c.Type() != CLIENT_TYPE_MASTER && item.Expired()
+}
+
+func (c *Client) Authenticated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authenticated
+}
+
+// requireAuth makes the client authenticate before running commands, unless the default user
+// needs no password
+func (c *Client) requireAuth() {
+	authenticated := ServerACL.AutoAuthenticates()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authenticated = authenticated
+}
+
+func (c *Client) authenticate(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user = user
+	c.authenticated = true
+}
+
+// Address returns the address of the client. Clients connected through the unix socket have no
+// address of their own, they are shown as the socket path.
+func (c *Client) Address() string {
+	if c.Conn == nil {
+		return ""
+	}
+	if c.isUnixSocket() {
+		return c.LocalAddress()
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+func (c *Client) LocalAddress() string {
+	if c.Conn == nil {
+		return ""
+	}
+	if c.isUnixSocket() {
+		return c.Conn.LocalAddr().String() + ":0"
+	}
+	return c.Conn.LocalAddr().String()
+}
+
+func (c *Client) isUnixSocket() bool {
+	return c.Conn.LocalAddr().Network() == "unix"
+}
+
+// beginCommand resets the reply tracking before running a command
+func (c *Client) beginCommand(command string, args []string) {
+	c.lastInteraction.Store(time.Now().UnixMilli())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replied, c.errorReply = false, false
+	c.skipReply, c.skipNextReply = c.skipNextReply, false
+	c.lastCommand = strings.ToLower(command)
+	c.rewrittenInput = nil
+	if (command == CLIENT || command == CONFIG) && len(args) > 0 {
+		c.lastCommand += "|" + strings.ToLower(args[0])
+	}
+}
+
+// beginQueuedCommand resets the propagation of a command run by EXEC, which runs several commands
+// as part of a single one
+func (c *Client) beginQueuedCommand() {
+	c.rewrittenInput = nil
+}
+
+// rewriteCommand replaces the current command by args in the replication stream and the append only
+// file, so replicas apply the same change the command made. Without args nothing is propagated.
+func (c *Client) rewriteCommand(args ...string) {
+	input := ""
+	if len(args) > 0 {
+		input = ToRespBulkStringArray(args...)
+	}
+	c.rewrittenInput = &input
+}
+
+// propagatedInput returns what to propagate for the current command, whose raw input is rawInput
+func (c *Client) propagatedInput(rawInput string) string {
+	if c.rewrittenInput != nil {
+		return *c.rewrittenInput
+	}
+	return rawInput
+}
+
+// endCommand publishes the state changed by the command, so other clients can read it
+func (c *Client) endCommand() {
+	if c.Transaction.Conn == nil {
+		c.multi.Store(-1)
+	} else {
+		c.multi.Store(int64(len(c.Transaction.Queue)))
+	}
+}
+
+func (c *Client) repliedWithError() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errorReply
+}
+
+func (c *Client) flags() string {
+	flags := ""
+	switch c.clientType {
+	case CLIENT_TYPE_REPLICA:
+		flags += "S"
+	case CLIENT_TYPE_MASTER:
+		flags += "M"
+	}
+	if c.monitor.Load() {
+		flags += "O"
+	}
+	if c.multi.Load() >= 0 {
+		flags += "x"
+	}
+	if c.blocked.Load() {
+		flags += "b"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if c.Conn != nil && c.isUnixSocket() {
+		flags += "U"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// Info describes the client in the format of CLIENT LIST and CLIENT INFO
+func (c *Client) Info() string {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 multi=%d qbuf=%d obl=0 oll=0 omem=%d events=r cmd=%s user=%s",
+		c.id, c.Address(), c.LocalAddress(), c.name,
+		int64(now.Sub(c.createdAt).Seconds()),
+		(now.UnixMilli()-c.lastInteraction.Load())/1000,
+		c.flags(), c.db, c.multi.Load(), c.queryBuffer.Load(), c.outputPending.Load(), c.lastCommand, c.user)
+}
+
+// ClientRegistry holds every connected client
+type ClientRegistry struct {
+	mu      sync.Mutex
+	clients map[int64]*Client
+	nextId  atomic.Int64
+
+	pauseMu sync.Mutex
+	// closed when the current pause ends, nil when clients are not paused
+	pauseDone       chan struct{}
+	pauseUntil      time.Time
+	pauseWritesOnly bool
+}
+
+var ServerClients = &ClientRegistry{clients: map[int64]*Client{}}
+
+// Register creates a client for conn with a new unique id
+func (r *ClientRegistry) Register(conn net.Conn, clientType string) *Client {
+	c := newClient(conn, clientType)
+	c.id = r.nextId.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c.id] = c
+	return c
+}
+
+func (r *ClientRegistry) Unregister(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// List returns every client, sorted by id
+func (r *ClientRegistry) List() []*Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	slices.SortFunc(clients, func(a, b *Client) int { return int(a.id - b.id) })
+	return clients
+}
+
+// CountByType returns the number of clients of the given type
+func (r *ClientRegistry) CountByType(clientType string) int {
+	count := 0
+	for _, c := range r.List() {
+		if c.Type() == clientType {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *ClientRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+// Pause stops clients from running commands, or only write commands if writesOnly is set, until
+// the timeout expires or Unpause is called. Pausing while already paused keeps the most restrictive
+// mode and the latest end time.
+func (r *ClientRegistry) Pause(timeout time.Duration, writesOnly bool) {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	until := time.Now().Add(timeout)
+	if r.pauseDone == nil || time.Now().After(r.pauseUntil) {
+		if r.pauseDone != nil {
+			close(r.pauseDone)
+		}
+		r.pauseDone = make(chan struct{})
+		r.pauseUntil = until
+		r.pauseWritesOnly = writesOnly
+		return
+	}
+	if until.After(r.pauseUntil) {
+		r.pauseUntil = until
+	}
+	r.pauseWritesOnly = r.pauseWritesOnly && writesOnly
+}
+
+func (r *ClientRegistry) Unpause() {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	if r.pauseDone != nil {
+		close(r.pauseDone)
+		r.pauseDone = nil
+	}
+}
+
+// WaitIfPaused blocks while clients are paused for the given kind of command
+func (r *ClientRegistry) WaitIfPaused(isWrite bool) {
+	for {
+		r.pauseMu.Lock()
+		done, until, writesOnly := r.pauseDone, r.pauseUntil, r.pauseWritesOnly
+		r.pauseMu.Unlock()
+
+		remaining := time.Until(until)
+		if done == nil || remaining <= 0 || (writesOnly && !isWrite) {
+			return
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// isPausable reports whether the command waits while clients are paused. CLIENT is never paused,
+// so paused clients can still be inspected and unpaused.
+func isPausable(c *Client, cmp CommandComponents) bool {
+	if cmp.Command == CLIENT {
+		return false
+	}
+	clientType := c.Type()
+	return clientType != CLIENT_TYPE_REPLICA && clientType != CLIENT_TYPE_MASTER
+}
+
+// clientKillFilter holds the filters of the new form of CLIENT KILL
+type clientKillFilter struct {
+	id         int64
+	addr       string
+	laddr      string
+	user       string
+	clientType string
+	skipMe     bool
+}
+
+func parseClientKillFilter(args []string) (clientKillFilter, error) {
+	filter := clientKillFilter{id: -1, skipMe: true}
+	if len(args)%2 != 0 {
+		return filter, errors.New("syntax error")
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return filter, errors.New("client-id should be greater than 0")
+			}
+			filter.id = id
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "USER":
+			filter.user = value
+		case "TYPE":
+			clientType, err := parseClientType(value)
+			if err != nil {
+				return filter, err
+			}
+			filter.clientType = clientType
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case CONFIG_YES:
+				filter.skipMe = true
+			case CONFIG_NO:
+				filter.skipMe = false
+			default:
+				return filter, errors.New("syntax error")
+			}
+		default:
+			return filter, errors.New("syntax error")
+		}
+	}
+	return filter, nil
+}
+
+func (f clientKillFilter) matches(c *Client, current *Client) bool {
+	c.mu.Lock()
+	user, clientType := c.user, c.clientType
+	c.mu.Unlock()
+	return (f.id == -1 || c.id == f.id) &&
+		(f.addr == "" || c.Address() == f.addr) &&
+		(f.laddr == "" || c.LocalAddress() == f.laddr) &&
+		(f.user == "" || user == f.user) &&
+		(f.clientType == "" || clientType == f.clientType) &&
+		!(f.skipMe && c == current)
+}
+
+func parseClientType(value string) (string, error) {
+	switch clientType := strings.ToLower(value); clientType {
+	case CLIENT_TYPE_NORMAL, CLIENT_TYPE_REPLICA, CLIENT_TYPE_MASTER, CLIENT_TYPE_PUBSUB:
+		return clientType, nil
+	case CLIENT_TYPE_SLAVE:
+		return CLIENT_TYPE_REPLICA, nil
+	default:
+		return "", fmt.Errorf("Unknown client type '%s'", value)
+	}
+}
+
+// ExecuteClient runs the CLIENT subcommands on behalf of client
+func ExecuteClient(args []string, client *Client) (string, error) {
+	subcommandArg, subcommand := args[0], strings.ToUpper(args[0])
+	args = args[1:]
+
+	switch subcommand {
+	case CLIENT_ID:
+		return ToRespInteger(int(client.id)), nil
+	case CLIENT_INFO:
+		return ToRespBulkString(client.Info() + "\n"), nil
+	case CLIENT_LIST:
+		return clientList(args)
+	case CLIENT_SETNAME:
+		if len(args) != 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'client|setname' command")), nil
+		}
+		for i := 0; i < len(args[0]); i++ {
+			if args[0][i] <= ' ' || args[0][i] > '~' {
+				return ToRespError(errors.New("Client names cannot contain spaces, newlines or special characters.")), nil
+			}
+		}
+		client.mu.Lock()
+		client.name = args[0]
+		client.mu.Unlock()
+		return ToRespSimpleString(OK), nil
+	case CLIENT_GETNAME:
+		name := client.Name()
+		if name == "" {
+			return NULL_BULK_STRING, nil
+		}
+		return ToRespBulkString(name), nil
+	case CLIENT_KILL:
+		return clientKill(args, client)
+	case CLIENT_PAUSE:
+		if len(args) < 1 || len(args) > 2 {
+			return ToRespError(errors.New("wrong number of arguments for 'client|pause' command")), nil
+		}
+		timeout, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || timeout < 0 {
+			return ToRespError(errors.New("timeout is not an integer or out of range")), nil
+		}
+		writesOnly := false
+		if len(args) == 2 {
+			switch strings.ToUpper(args[1]) {
+			case CLIENT_PAUSE_WRITE:
+				writesOnly = true
+			case CLIENT_PAUSE_ALL:
+			default:
+				return ToRespError(errors.New("syntax error")), nil
+			}
+		}
+		ServerClients.Pause(time.Duration(timeout)*time.Millisecond, writesOnly)
+		return ToRespSimpleString(OK), nil
+	case CLIENT_UNPAUSE:
+		ServerClients.Unpause()
+		return ToRespSimpleString(OK), nil
+	case CLIENT_NO_EVICT:
+		if len(args) != 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'client|no-evict' command")), nil
+		}
+		switch strings.ToUpper(args[0]) {
+		case "ON":
+			client.mu.Lock()
+			client.noEvict = true
+			client.mu.Unlock()
+		case "OFF":
+			client.mu.Lock()
+			client.noEvict = false
+			client.mu.Unlock()
+		default:
+			return ToRespError(errors.New("syntax error")), nil
+		}
+		return ToRespSimpleString(OK), nil
+	case CLIENT_REPLY:
+		if len(args) != 1 {
+			return ToRespError(errors.New("wrong number of arguments for 'client|reply' command")), nil
+		}
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case CLIENT_REPLY_ON:
+			client.replyOff = false
+			client.skipReply = false
+			return ToRespSimpleString(OK), nil
+		case CLIENT_REPLY_OFF:
+			client.replyOff = true
+			return "", nil
+		case CLIENT_REPLY_SKIP:
+			client.skipNextReply = true
+			return "", nil
+		default:
+			return ToRespError(errors.New("syntax error")), nil
+		}
+	default:
+		return ToRespError(fmt.Errorf("unknown subcommand '%s'. Try CLIENT HELP.", subcommandArg)), nil
+	}
+}
+
+// clientList handles the arguments of CLIENT LIST: [TYPE type] [ID id [id ...]]
+func clientList(args []string) (string, error) {
+	clientType := ""
+	ids := []int64{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TYPE":
+			if i+1 >= len(args) {
+				return ToRespError(errors.New("syntax error")), nil
+			}
+			parsed, err := parseClientType(args[i+1])
+			if err != nil {
+				return ToRespError(err), nil
+			}
+			clientType = parsed
+			i++
+		case "ID":
+			if i+1 >= len(args) {
+				return ToRespError(errors.New("syntax error")), nil
+			}
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || id <= 0 {
+					return ToRespError(fmt.Errorf("Invalid client ID")), nil
+				}
+				ids = append(ids, id)
+				i++
+			}
+		default:
+			return ToRespError(errors.New("syntax error")), nil
+		}
+	}
+
+	lines := strings.Builder{}
+	for _, c := range ServerClients.List() {
+		if clientType != "" && c.Type() != clientType {
+			continue
+		}
+		if len(ids) > 0 && !slices.Contains(ids, c.id) {
+			continue
+		}
+		lines.WriteString(c.Info() + "\n")
+	}
+	return ToRespBulkString(lines.String()), nil
+}
+
+// clientKill handles both forms of CLIENT KILL: the old `CLIENT KILL addr:port` and the filter form
+func clientKill(args []string, current *Client) (string, error) {
+	if len(args) == 0 {
+		return ToRespError(errors.New("wrong number of arguments for 'client|kill' command")), nil
+	}
+
+	if len(args) == 1 {
+		for _, c := range ServerClients.List() {
+			if c.Address() == args[0] {
+				c.Close()
+				return ToRespSimpleString(OK), nil
+			}
+		}
+		return ToRespError(ErrNoSuchClient), nil
+	}
+
+	filter, err := parseClientKillFilter(args)
+	if err != nil {
+		return ToRespError(err), nil
+	}
+	killed := 0
+	for _, c := range ServerClients.List() {
+		if filter.matches(c, current) {
+			c.Close()
+			killed++
+		}
+	}
+	return ToRespInteger(killed), nil
+}
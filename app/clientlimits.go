@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how often the connection loop wakes up to check the idle timeout and the output buffer limits
+const CLIENT_CRON_INTERVAL = time.Second
+
+const DEFAULT_OUTPUT_BUFFER_LIMITS = "normal 0 0 0 replica 268435456 67108864 60 pubsub 33554432 8388608 60"
+
+var ErrMaxClients = errors.New("max number of clients reached")
+var ErrOutputBufferLimit = errors.New("client output buffer limit reached")
+
+// OutputBufferLimit is the client-output-buffer-limit of a class of clients. Clients are disconnected
+// once their pending replies exceed the hard limit, or stay above the soft limit for longer than
+// softSeconds. A limit of 0 disables it.
+type OutputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int64
+}
+
+// classes of clients accepted by client-output-buffer-limit, in the order they are written
+var outputBufferLimitClasses = []string{CLIENT_TYPE_NORMAL, CLIENT_TYPE_REPLICA, CLIENT_TYPE_PUBSUB}
+
+type parsedOutputBufferLimits struct {
+	value  string
+	limits map[string]OutputBufferLimit
+}
+
+// the limits parsed from the last value of client-output-buffer-limit, so writes do not parse it again
+var outputBufferLimitsCache atomic.Pointer[parsedOutputBufferLimits]
+
+// ParseOutputBufferLimits parses "<class> <hard> <soft> <soft seconds>" groups on top of the limits
+// in base, so only the classes given in value change
+func ParseOutputBufferLimits(value string, base string) (map[string]OutputBufferLimit, error) {
+	limits := map[string]OutputBufferLimit{}
+	if base != "" {
+		var err error
+		limits, err = ParseOutputBufferLimits(base, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class, err := parseClientType(fields[i])
+		if err != nil || class == CLIENT_TYPE_MASTER {
+			return nil, errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, hardErr := ParseMemorySize(fields[i+1])
+		soft, softErr := ParseMemorySize(fields[i+2])
+		softSeconds, secondsErr := strconv.ParseInt(fields[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || softSeconds < 0 {
+			return nil, errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = OutputBufferLimit{hard, soft, softSeconds}
+	}
+	return limits, nil
+}
+
+// FormatOutputBufferLimits writes the limits of every class in the form accepted by ParseOutputBufferLimits
+func FormatOutputBufferLimits(limits map[string]OutputBufferLimit) string {
+	groups := []string{}
+	for _, class := range outputBufferLimitClasses {
+		limit := limits[class]
+		groups = append(groups, fmt.Sprintf("%s %d %d %d", class, limit.hard, limit.soft, limit.softSeconds))
+	}
+	return strings.Join(groups, " ")
+}
+
+// GetOutputBufferLimit returns the configured limit of a class of clients
+func GetOutputBufferLimit(class string) OutputBufferLimit {
+	value := ServerConfig.Get(CONFIG_CLIENT_OUTPUT_BUFFER_LIMIT)
+	parsed := outputBufferLimitsCache.Load()
+	if parsed == nil || parsed.value != value {
+		limits, err := ParseOutputBufferLimits(value, "")
+		if err != nil {
+			return OutputBufferLimit{}
+		}
+		parsed = &parsedOutputBufferLimits{value, limits}
+		outputBufferLimitsCache.Store(parsed)
+	}
+	return parsed.limits[class]
+}
+
+// outputBufferLimit returns the output buffer limit of the client. The master connection is never limited.
+func (c *Client) outputBufferLimit() (OutputBufferLimit, bool) {
+	switch c.Type() {
+	case CLIENT_TYPE_MASTER:
+		return OutputBufferLimit{}, false
+	case CLIENT_TYPE_REPLICA:
+		return GetOutputBufferLimit(CLIENT_TYPE_REPLICA), true
+	case CLIENT_TYPE_PUBSUB:
+		return GetOutputBufferLimit(CLIENT_TYPE_PUBSUB), true
+	default:
+		return GetOutputBufferLimit(CLIENT_TYPE_NORMAL), true
+	}
+}
+
+// outputLimitReached reports whether pending reply bytes break the output buffer limit of the client.
+// The soft limit is only broken once it has been exceeded for longer than its duration.
+func (c *Client) outputLimitReached(pending int64) bool {
+	limit, limited := c.outputBufferLimit()
+	if !limited {
+		return false
+	}
+	if limit.hard > 0 && pending > limit.hard {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit.soft == 0 || pending <= limit.soft {
+		c.softLimitSince = time.Time{}
+		return false
+	}
+	if c.softLimitSince.IsZero() {
+		c.softLimitSince = time.Now()
+		return false
+	}
+	return time.Since(c.softLimitSince) > time.Duration(limit.softSeconds)*time.Second
+}
+
+// softLimitDeadline returns the time by which a write must complete before the client breaks its
+// soft limit, or the zero time if the client is below it
+func (c *Client) softLimitDeadline() time.Time {
+	c.mu.Lock()
+	since := c.softLimitSince
+	c.mu.Unlock()
+	if since.IsZero() {
+		return time.Time{}
+	}
+	limit, _ := c.outputBufferLimit()
+	// a second of slack, since the limit is only broken once the duration has fully elapsed
+	return since.Add(time.Duration(limit.softSeconds+1) * time.Second)
+}
+
+// closeForOutputLimit disconnects a client which broke its output buffer limit
+func (c *Client) closeForOutputLimit() {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	fmt.Printf("Client id=%d addr=%s closed for overcoming of output buffer limits.\n", c.id, c.Address())
+	Stats.ClientOutputLimitDisconnections.Add(1)
+	c.Close()
+}
+
+// idleTimedOut reports whether the client has been idle for longer than the timeout parameter.
+// Replicas, the master, monitors and blocked clients are never timed out.
+func (c *Client) idleTimedOut() bool {
+	timeout := ServerConfig.GetInt(CONFIG_TIMEOUT)
+	if timeout <= 0 || c.monitor.Load() || c.blocked.Load() {
+		return false
+	}
+	if clientType := c.Type(); clientType == CLIENT_TYPE_REPLICA || clientType == CLIENT_TYPE_MASTER {
+		return false
+	}
+	idle := time.Now().UnixMilli() - c.lastInteraction.Load()
+	return idle > int64(timeout)*1000
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var Command = RespCommand{
+	arity:      -1,
+	flags:      []string{"loading", "stale"},
+	categories: []string{"@slow", "@connection"},
+	summary:    "Returns detailed information about all commands.",
+	since:      "2.8.13",
+	group:      "server",
+	Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+		if len(args) == 0 {
+			return commandInfoArray(SortedCommandNames()), nil
+		}
+
+		subcommand, subArgs := strings.ToUpper(args[0]), args[1:]
+		switch subcommand {
+		case COUNT:
+			return ToRespInteger(len(RespCommands)), nil
+		case INFO:
+			if len(subArgs) == 0 {
+				return commandInfoArray(SortedCommandNames()), nil
+			}
+			return commandInfoArray(subArgs), nil
+		case DOCS:
+			if len(subArgs) == 0 {
+				return commandDocsArray(SortedCommandNames()), nil
+			}
+			return commandDocsArray(subArgs), nil
+		case GETKEYS:
+			if len(subArgs) == 0 {
+				return ToRespError(errors.New("wrong number of arguments for 'command|getkeys' command")), nil
+			}
+			keys, err := GetCommandKeys(subArgs[0], subArgs[1:])
+			if err != nil {
+				return ToRespError(err), nil
+			}
+			return ToRespBulkStringArray(keys...), nil
+		case LIST:
+			names, err := filterCommandNames(subArgs)
+			if err != nil {
+				return ToRespError(err), nil
+			}
+			return ToRespBulkStringArray(names...), nil
+		default:
+			return ToRespError(errors.New("unknown subcommand '" + args[0] + "'. Try COMMAND HELP.")), nil
+		}
+	},
+}
+
+func init() {
+	// registered here since COMMAND itself needs to read RespCommands
+	RespCommands[COMMAND] = Command
+}
+
+// SortedCommandNames returns the lowercase names of every supported command, sorted alphabetically
+func SortedCommandNames() []string {
+	names := []string{}
+	for name := range RespCommands {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CheckArity reports whether argc, the number of arguments including the command name,
+// is valid for the command
+func (c *RespCommand) CheckArity(argc int) bool {
+	if c.arity >= 0 {
+		return argc == c.arity
+	}
+	return argc >= -c.arity
+}
+
+// KeyPositions returns the positions of the key arguments in args, counting the command name as position 0
+func (c *RespCommand) KeyPositions(args []string) []int {
+	if c.getKeys != nil {
+		return c.getKeys(args)
+	}
+
+	positions := []int{}
+	if c.firstKey == 0 {
+		return positions
+	}
+
+	lastKey := c.lastKey
+	if lastKey < 0 {
+		lastKey = len(args) + 1 + lastKey
+	}
+	for i := c.firstKey; i <= lastKey && i <= len(args); i += c.keyStep {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// GetCommandKeys returns the key arguments that the command would access if called with args
+func GetCommandKeys(command string, args []string) ([]string, error) {
+	respCommand, exists := RespCommands[strings.ToUpper(command)]
+	if !exists {
+		return nil, errors.New("Invalid command specified")
+	}
+	if !respCommand.CheckArity(len(args) + 1) {
+		return nil, errors.New("Invalid number of arguments specified for command")
+	}
+
+	keys := []string{}
+	for _, position := range respCommand.KeyPositions(args) {
+		keys = append(keys, args[position-1])
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("The command has no key arguments")
+	}
+	return keys, nil
+}
+
+func xreadKeys(args []string) []int {
+	positions := []int{}
+	for i, arg := range args {
+		if strings.ToUpper(arg) != XREAD_STREAMS {
+			continue
+		}
+		numKeys := (len(args) - i - 1) / 2
+		for j := 0; j < numKeys; j++ {
+			positions = append(positions, i+2+j)
+		}
+		break
+	}
+	return positions
+}
+
+func commandInfoArray(names []string) string {
+	items := []string{}
+	for _, name := range names {
+		respCommand, exists := RespCommands[strings.ToUpper(name)]
+		if !exists {
+			items = append(items, NULL_ARRAY)
+			continue
+		}
+		items = append(items, respCommand.infoEntry(strings.ToLower(name)))
+	}
+	return ConcatIntoRespArray(items)
+}
+
+func (c *RespCommand) infoEntry(name string) string {
+	flags := []string{}
+	for _, flag := range c.flags {
+		flags = append(flags, ToRespSimpleString(flag))
+	}
+	categories := []string{}
+	for _, category := range c.categories {
+		categories = append(categories, ToRespSimpleString(category))
+	}
+	keyStep := c.keyStep
+	if c.firstKey == 0 {
+		keyStep = 0
+	}
+
+	return ConcatIntoRespArray([]string{
+		ToRespBulkString(name),
+		ToRespInteger(c.arity),
+		ConcatIntoRespArray(flags),
+		ToRespInteger(c.firstKey),
+		ToRespInteger(c.lastKey),
+		ToRespInteger(keyStep),
+		ConcatIntoRespArray(categories),
+		ConcatIntoRespArray([]string{}), // tips
+		ConcatIntoRespArray([]string{}), // key specifications
+		ConcatIntoRespArray([]string{}), // subcommands
+	})
+}
+
+func commandDocsArray(names []string) string {
+	items := []string{}
+	for _, name := range names {
+		respCommand, exists := RespCommands[strings.ToUpper(name)]
+		if !exists {
+			continue
+		}
+		items = append(items,
+			ToRespBulkString(strings.ToLower(name)),
+			ToRespBulkStringArray("summary", respCommand.summary, "since", respCommand.since, "group", respCommand.group),
+		)
+	}
+	return ConcatIntoRespArray(items)
+}
+
+// filterCommandNames handles the arguments of COMMAND LIST: [FILTERBY <MODULE name | ACLCAT category | PATTERN pattern>]
+func filterCommandNames(args []string) ([]string, error) {
+	names := SortedCommandNames()
+	if len(args) == 0 {
+		return names, nil
+	}
+	if len(args) != 3 || strings.ToUpper(args[0]) != FILTERBY {
+		return nil, errors.New("syntax error")
+	}
+
+	filter, value := strings.ToUpper(args[1]), args[2]
+	filtered := []string{}
+	switch filter {
+	case MODULE:
+		// modules are not supported, so no command can belong to one
+		return filtered, nil
+	case ACLCAT:
+		category := "@" + strings.ToLower(strings.TrimPrefix(value, "@"))
+		for _, name := range names {
+			if slices.Contains(RespCommands[strings.ToUpper(name)].categories, category) {
+				filtered = append(filtered, name)
+			}
+		}
+	case PATTERN:
+		for _, name := range names {
+			if GlobMatch(value, name, true) {
+				filtered = append(filtered, name)
+			}
+		}
+	default:
+		return nil, errors.New("syntax error")
+	}
+
+	return filtered, nil
+}
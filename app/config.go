@@ -0,0 +1,596 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Configuration parameter names
+const (
+	CONFIG_PORT       = "port"
+	CONFIG_REPLICAOF  = "replicaof"
+	CONFIG_SLAVEOF    = "slaveof"
+	CONFIG_DIR        = RDB_DIR_ARG
+	CONFIG_DBFILENAME = RDB_FILENAME_ARG
+	CONFIG_SAVE       = "save"
+	CONFIG_EXECUTOR   = "executor"
+	CONFIG_INCLUDE    = "include"
+	CONFIG_MAXMEMORY  = "maxmemory"
+	CONFIG_APPENDONLY = "appendonly"
+	CONFIG_AOF_NAME   = "appendfilename"
+	CONFIG_AOF_FSYNC  = "appendfsync"
+	CONFIG_DATABASES  = "databases"
+	// latency histograms of every command, reported by INFO latencystats
+	CONFIG_LATENCY_TRACKING             = "latency-tracking"
+	CONFIG_LATENCY_TRACKING_PERCENTILES = "latency-tracking-info-percentiles"
+	CONFIG_SLOWLOG_SLOWER_THAN          = "slowlog-log-slower-than"
+	CONFIG_SLOWLOG_MAX_LEN              = "slowlog-max-len"
+	CONFIG_LATENCY_MONITOR_THRESHOLD    = "latency-monitor-threshold"
+
+	CONFIG_TIMEOUT                    = "timeout"
+	CONFIG_MAXCLIENTS                 = "maxclients"
+	CONFIG_CLIENT_OUTPUT_BUFFER_LIMIT = "client-output-buffer-limit"
+
+	CONFIG_REQUIREPASS    = "requirepass"
+	CONFIG_MASTERAUTH     = "masterauth"
+	CONFIG_ACLFILE        = "aclfile"
+	CONFIG_ACLLOG_MAX_LEN = "acllog-max-len"
+
+	CONFIG_BIND           = "bind"
+	CONFIG_UNIXSOCKET     = "unixsocket"
+	CONFIG_UNIXSOCKETPERM = "unixsocketperm"
+	CONFIG_PROTECTED_MODE = "protected-mode"
+
+	CONFIG_TLS_PORT         = "tls-port"
+	CONFIG_TLS_CERT_FILE    = "tls-cert-file"
+	CONFIG_TLS_KEY_FILE     = "tls-key-file"
+	CONFIG_TLS_CA_CERT_FILE = "tls-ca-cert-file"
+	CONFIG_TLS_AUTH_CLIENTS = "tls-auth-clients"
+	CONFIG_TLS_REPLICATION  = "tls-replication"
+)
+
+// CONFIG subcommands
+const (
+	CONFIG_SUBCOMMAND_GET       = "GET"
+	CONFIG_SUBCOMMAND_SET       = "SET"
+	CONFIG_SUBCOMMAND_RESETSTAT = "RESETSTAT"
+	CONFIG_SUBCOMMAND_REWRITE   = "REWRITE"
+)
+
+// Boolean configuration values
+const (
+	CONFIG_YES = "yes"
+	CONFIG_NO  = "no"
+)
+
+// CONFIG REWRITE appends the parameters missing from the configuration file after this line
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+type configKind int
+
+// Configuration parameter kinds, used to validate values
+const (
+	CONFIG_KIND_STRING configKind = iota
+	CONFIG_KIND_INT
+	CONFIG_KIND_ENUM
+	CONFIG_KIND_BOOL
+	// memory sizes, accepting units such as 100mb or 1gb
+	CONFIG_KIND_MEMORY
+	// space separated list of percentiles between 0 and 100
+	CONFIG_KIND_PERCENTILES
+	// "<class> <hard> <soft> <soft seconds>" groups, only changing the classes given
+	CONFIG_KIND_OUTPUT_BUFFER_LIMIT
+)
+
+// memory units accepted by memory parameters, in bytes
+var configMemoryUnits = map[string]int64{
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+type ConfigParameter struct {
+	name         string
+	kind         configKind
+	defaultValue string
+	// allowed values for enum parameters
+	enumValues []string
+	// other names accepted for the parameter
+	aliases []string
+	// immutable parameters can only be set on startup
+	immutable bool
+	// sensitive parameters hold passwords, their values are redacted from MONITOR and the slow log
+	sensitive bool
+	// multiArg parameters hold several space separated arguments, which are written unquoted
+	multiArg bool
+	// repeatable parameters may be given several times on startup, every directive adding its
+	// arguments to the ones before it
+	repeatable bool
+	// lineArgs is the number of arguments of each directive of a repeatable parameter, CONFIG
+	// REWRITE writes the value back as one directive per lineArgs arguments
+	lineArgs int
+	// apply is called by CONFIG SET to put a new value into effect
+	apply func(value string) error
+	value string
+}
+
+// configDefinitions holds every parameter known to the server, along with its default value
+var configDefinitions = []ConfigParameter{
+	{name: CONFIG_PORT, kind: CONFIG_KIND_INT, defaultValue: strconv.Itoa(DEFAULT_PORT), immutable: true},
+	{name: CONFIG_REPLICAOF, kind: CONFIG_KIND_STRING, defaultValue: DEFAULT_MASTER, aliases: []string{CONFIG_SLAVEOF}, immutable: true, multiArg: true},
+	{name: CONFIG_DIR, kind: CONFIG_KIND_STRING, defaultValue: RDB_DEFAULT_DIR, apply: applyDir},
+	{name: CONFIG_DBFILENAME, kind: CONFIG_KIND_STRING, defaultValue: RDB_DEFAULT_FILENAME},
+	{name: CONFIG_SAVE, kind: CONFIG_KIND_STRING, defaultValue: "", multiArg: true, repeatable: true, lineArgs: 2},
+	{name: CONFIG_EXECUTOR, kind: CONFIG_KIND_ENUM, defaultValue: EXECUTOR_DIRECT, enumValues: []string{EXECUTOR_DIRECT, EXECUTOR_SINGLE}, immutable: true},
+	{name: CONFIG_MAXMEMORY, kind: CONFIG_KIND_MEMORY, defaultValue: "0"},
+	{name: CONFIG_APPENDONLY, kind: CONFIG_KIND_BOOL, defaultValue: CONFIG_NO, apply: applyAppendOnly},
+	{name: CONFIG_AOF_NAME, kind: CONFIG_KIND_STRING, defaultValue: AOF_DEFAULT_FILENAME, immutable: true},
+	{name: CONFIG_AOF_FSYNC, kind: CONFIG_KIND_ENUM, defaultValue: AOF_FSYNC_EVERYSEC, enumValues: []string{AOF_FSYNC_ALWAYS, AOF_FSYNC_EVERYSEC, AOF_FSYNC_NO}},
+	{name: CONFIG_DATABASES, kind: CONFIG_KIND_INT, defaultValue: strconv.Itoa(DEFAULT_DATABASES), immutable: true},
+	{name: CONFIG_LATENCY_TRACKING, kind: CONFIG_KIND_BOOL, defaultValue: CONFIG_YES},
+	{name: CONFIG_LATENCY_TRACKING_PERCENTILES, kind: CONFIG_KIND_PERCENTILES, defaultValue: "50 99 99.9", multiArg: true},
+	{name: CONFIG_SLOWLOG_SLOWER_THAN, kind: CONFIG_KIND_INT, defaultValue: "10000"},
+	{name: CONFIG_SLOWLOG_MAX_LEN, kind: CONFIG_KIND_INT, defaultValue: "128", apply: applySlowLogMaxLen},
+	{name: CONFIG_LATENCY_MONITOR_THRESHOLD, kind: CONFIG_KIND_INT, defaultValue: "0"},
+	{name: CONFIG_TIMEOUT, kind: CONFIG_KIND_INT, defaultValue: "0"},
+	{name: CONFIG_MAXCLIENTS, kind: CONFIG_KIND_INT, defaultValue: "10000"},
+	{name: CONFIG_CLIENT_OUTPUT_BUFFER_LIMIT, kind: CONFIG_KIND_OUTPUT_BUFFER_LIMIT, defaultValue: DEFAULT_OUTPUT_BUFFER_LIMITS, multiArg: true},
+	{name: CONFIG_REQUIREPASS, kind: CONFIG_KIND_STRING, defaultValue: "", sensitive: true, apply: applyRequirePass},
+	{name: CONFIG_MASTERAUTH, kind: CONFIG_KIND_STRING, defaultValue: "", sensitive: true},
+	{name: CONFIG_ACLFILE, kind: CONFIG_KIND_STRING, defaultValue: "", immutable: true},
+	{name: CONFIG_ACLLOG_MAX_LEN, kind: CONFIG_KIND_INT, defaultValue: "128"},
+	{name: CONFIG_BIND, kind: CONFIG_KIND_STRING, defaultValue: DEFAULT_BIND, immutable: true, multiArg: true},
+	{name: CONFIG_UNIXSOCKET, kind: CONFIG_KIND_STRING, defaultValue: "", immutable: true},
+	{name: CONFIG_UNIXSOCKETPERM, kind: CONFIG_KIND_INT, defaultValue: "0", immutable: true},
+	{name: CONFIG_PROTECTED_MODE, kind: CONFIG_KIND_BOOL, defaultValue: CONFIG_YES},
+	{name: CONFIG_TLS_PORT, kind: CONFIG_KIND_INT, defaultValue: "0", immutable: true},
+	{name: CONFIG_TLS_CERT_FILE, kind: CONFIG_KIND_STRING, defaultValue: "", immutable: true},
+	{name: CONFIG_TLS_KEY_FILE, kind: CONFIG_KIND_STRING, defaultValue: "", immutable: true},
+	{name: CONFIG_TLS_CA_CERT_FILE, kind: CONFIG_KIND_STRING, defaultValue: "", immutable: true},
+	{name: CONFIG_TLS_AUTH_CLIENTS, kind: CONFIG_KIND_ENUM, defaultValue: TLS_AUTH_CLIENTS_YES, enumValues: []string{TLS_AUTH_CLIENTS_YES, TLS_AUTH_CLIENTS_NO, TLS_AUTH_CLIENTS_OPTIONAL}, immutable: true},
+	{name: CONFIG_TLS_REPLICATION, kind: CONFIG_KIND_BOOL, defaultValue: CONFIG_NO, immutable: true},
+}
+
+// ConfigStore holds the value of every configuration parameter
+type ConfigStore struct {
+	mu sync.RWMutex
+	// setMu serializes SetMany from validation to rollback. It is not mu, since apply functions
+	// read the configuration.
+	setMu  sync.Mutex
+	params map[string]*ConfigParameter
+	// absolute path of the configuration file the server was started with, if any
+	file string
+}
+
+var ServerConfig *ConfigStore
+
+func init() {
+	// created on init since the apply functions of some parameters read the configuration themselves
+	ServerConfig = NewConfigStore()
+}
+
+func NewConfigStore() *ConfigStore {
+	c := &ConfigStore{params: map[string]*ConfigParameter{}}
+	for _, definition := range configDefinitions {
+		param := definition
+		param.value = param.defaultValue
+		c.params[param.name] = &param
+		for _, alias := range param.aliases {
+			c.params[alias] = &param
+		}
+	}
+	return c
+}
+
+// Get returns the value of the parameter, or an empty string if it does not exist
+func (c *ConfigStore) Get(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	param, exists := c.params[strings.ToLower(name)]
+	if !exists {
+		return ""
+	}
+	return param.value
+}
+
+// GetInt returns the value of an integer parameter
+func (c *ConfigStore) GetInt(name string) int {
+	value, _ := strconv.Atoi(c.Get(name))
+	return value
+}
+
+// Set validates and stores the value of the parameter
+func (c *ConfigStore) Set(name, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	param, exists := c.params[strings.ToLower(name)]
+	if !exists {
+		return fmt.Errorf("unknown parameter '%s'", name)
+	}
+
+	value, err := param.validate(value)
+	if err != nil {
+		return err
+	}
+	param.value = value
+	return nil
+}
+
+// definition returns a copy of the parameter called name, which may be an alias
+func (c *ConfigStore) definition(name string) (ConfigParameter, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	param, exists := c.params[strings.ToLower(name)]
+	if !exists {
+		return ConfigParameter{}, false
+	}
+	return *param, true
+}
+
+func (c *ConfigStore) File() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.file
+}
+
+func (c *ConfigStore) setFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = absPath
+	return nil
+}
+
+// Match returns the names of the parameters matching the glob pattern, sorted alphabetically.
+// Aliases are only returned when the pattern is exactly the alias.
+func (c *ConfigStore) Match(pattern string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := []string{}
+	for name, param := range c.params {
+		isAlias := name != param.name
+		if (isAlias && name == strings.ToLower(pattern)) || (!isAlias && GlobMatch(pattern, name, true)) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SetMany validates every value and then applies them in order. If applying a value fails, the
+// values that were already applied are restored, so either every parameter changes or none does.
+// Calls run one at a time, so a rollback never restores over the values of another call.
+func (c *ConfigStore) SetMany(names, values []string) error {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	c.mu.RLock()
+	params := []*ConfigParameter{}
+	normalised := []string{}
+	for i, name := range names {
+		param, exists := c.params[strings.ToLower(name)]
+		if !exists || slices.Contains(params, param) {
+			c.mu.RUnlock()
+			return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", name)
+		}
+		if param.immutable {
+			c.mu.RUnlock()
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name)
+		}
+		value, err := param.validate(values[i])
+		if err != nil {
+			c.mu.RUnlock()
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+		}
+		params = append(params, param)
+		normalised = append(normalised, value)
+	}
+	c.mu.RUnlock()
+
+	previous := []string{}
+	for i, param := range params {
+		previous = append(previous, c.Get(param.name))
+		// the value is stored before applying it, since apply functions read the configuration
+		c.Set(param.name, normalised[i])
+		if param.apply == nil {
+			continue
+		}
+		err := param.apply(normalised[i])
+		if err == nil {
+			continue
+		}
+
+		for j := i; j >= 0; j-- {
+			c.Set(params[j].name, previous[j])
+			if j < i && params[j].apply != nil {
+				params[j].apply(previous[j])
+			}
+		}
+		return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %v", names[i], err)
+	}
+
+	return nil
+}
+
+// validate checks the value against the parameter kind, returning it in its normalised form
+func (p *ConfigParameter) validate(value string) (string, error) {
+	switch p.kind {
+	case CONFIG_KIND_INT:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("argument couldn't be parsed into an integer")
+		}
+	case CONFIG_KIND_ENUM:
+		value = strings.ToLower(value)
+		if !slices.Contains(p.enumValues, value) {
+			return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(p.enumValues, ", "))
+		}
+	case CONFIG_KIND_BOOL:
+		value = strings.ToLower(value)
+		if value != CONFIG_YES && value != CONFIG_NO {
+			return "", fmt.Errorf("argument must be 'yes' or 'no'")
+		}
+	case CONFIG_KIND_MEMORY:
+		bytes, err := ParseMemorySize(value)
+		if err != nil {
+			return "", err
+		}
+		value = strconv.FormatInt(bytes, 10)
+	case CONFIG_KIND_PERCENTILES:
+		percentiles, err := ParsePercentiles(value)
+		if err != nil {
+			return "", err
+		}
+		formatted := []string{}
+		for _, percentile := range percentiles {
+			formatted = append(formatted, strconv.FormatFloat(percentile, 'f', -1, 64))
+		}
+		value = strings.Join(formatted, " ")
+	case CONFIG_KIND_OUTPUT_BUFFER_LIMIT:
+		limits, err := ParseOutputBufferLimits(value, p.value)
+		if err != nil {
+			return "", err
+		}
+		value = FormatOutputBufferLimits(limits)
+	}
+	return value, nil
+}
+
+// ParseMemorySize parses sizes such as 1024, 100mb or 1gb into bytes
+func ParseMemorySize(value string) (int64, error) {
+	lowerValue := strings.ToLower(value)
+	digits := strings.TrimRightFunc(lowerValue, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	unit := lowerValue[len(digits):]
+
+	multiplier := int64(1)
+	if unit != "" && unit != "b" {
+		unitMultiplier, exists := configMemoryUnits[unit]
+		if !exists {
+			return 0, fmt.Errorf("argument must be a memory value")
+		}
+		multiplier = unitMultiplier
+	}
+
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return size * multiplier, nil
+}
+
+// ParsePercentiles parses a space separated list of percentiles, such as "50 99 99.9"
+func ParsePercentiles(value string) ([]float64, error) {
+	percentiles := []float64{}
+	for _, field := range strings.Fields(value) {
+		percentile, err := strconv.ParseFloat(field, 64)
+		if err != nil || percentile < 0 || percentile > 100 {
+			return nil, fmt.Errorf("latency-tracking-info-percentiles should be between 0.0 and 100.0")
+		}
+		percentiles = append(percentiles, percentile)
+	}
+	return percentiles, nil
+}
+
+func applyDir(value string) error {
+	info, err := os.Stat(value)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", value)
+	}
+	return nil
+}
+
+func applyAppendOnly(value string) error {
+	if value == CONFIG_YES {
+		return StartAppendOnly()
+	}
+	StopAppendOnly()
+	return nil
+}
+
+// RewriteConfigFile updates the configuration file with the current value of every parameter.
+// Lines of known parameters are replaced in place, every other line, including comments, is kept
+// as it is. Parameters missing from the file are appended only if they differ from their default.
+func (c *ConfigStore) RewriteConfigFile() error {
+	path := c.File()
+	if path == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	written := map[*ConfigParameter]bool{}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == CONFIG_REWRITE_SIGNATURE {
+			continue
+		}
+		args, err := SplitConfigArgs(trimmed)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || err != nil || len(args) == 0 {
+			lines = append(lines, line)
+			continue
+		}
+
+		param, exists := c.params[strings.ToLower(args[0])]
+		if !exists {
+			lines = append(lines, line)
+			continue
+		}
+		// duplicated directives are dropped, the first one is replaced by every line of the current value
+		if !written[param] {
+			lines = append(lines, param.configLines()...)
+			written[param] = true
+		}
+	}
+
+	missing := []string{}
+	for _, definition := range configDefinitions {
+		param := c.params[definition.name]
+		if !written[param] && param.value != param.defaultValue {
+			missing = append(missing, param.configLines()...)
+		}
+	}
+	if len(missing) > 0 {
+		lines = append(lines, CONFIG_REWRITE_SIGNATURE)
+		lines = append(lines, missing...)
+	}
+
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// configLines formats the parameter as configuration file lines. Repeatable parameters take one
+// line per directive, every other parameter a single line.
+func (p *ConfigParameter) configLines() []string {
+	if !p.multiArg || p.value == "" {
+		return []string{p.name + " " + QuoteConfigArg(p.value)}
+	}
+
+	args := []string{}
+	for _, arg := range strings.Fields(p.value) {
+		args = append(args, QuoteConfigArg(arg))
+	}
+	if !p.repeatable || p.lineArgs == 0 {
+		return []string{p.name + " " + strings.Join(args, " ")}
+	}
+	lines := []string{}
+	for len(args) > 0 {
+		count := min(p.lineArgs, len(args))
+		lines = append(lines, p.name+" "+strings.Join(args[:count], " "))
+		args = args[count:]
+	}
+	return lines
+}
+
+// QuoteConfigArg quotes the argument when it would otherwise not be read back as a single argument
+func QuoteConfigArg(arg string) string {
+	needsQuotes := arg == ""
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		if isConfigSpace(c) || c == '"' || c == '\'' || c == '\\' || c < 32 || c > 126 {
+			needsQuotes = true
+			break
+		}
+	}
+	if !needsQuotes {
+		return arg
+	}
+	return QuoteRepr(arg)
+}
+
+// LoadServerConfig populates ServerConfig from the command line arguments. The first argument is the
+// path of a configuration file unless it starts with `-`. Options given as `--name value` are applied
+// on top of it. Directives of the file which the server does not support are skipped, so a stock
+// redis.conf can be loaded, while unknown options are errors.
+func LoadServerConfig(args []string) error {
+	directives := []ConfigDirective{}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path := args[0]
+		args = args[1:]
+		if err := ServerConfig.setFile(path); err != nil {
+			return err
+		}
+		fileDirectives, err := ParseConfigFile(path)
+		if err != nil {
+			return err
+		}
+		directives = append(directives, fileDirectives...)
+	}
+
+	optionDirectives, err := ParseConfigOptions(args)
+	if err != nil {
+		return err
+	}
+	directives = append(directives, optionDirectives...)
+
+	// repeatable parameters given more than once add to the value of their first directive, which
+	// replaces the default value
+	repeated := map[string]bool{}
+	for _, directive := range directives {
+		param, exists := ServerConfig.definition(directive.Name)
+		if !exists && directive.File != "" {
+			fmt.Printf("Skipping unsupported directive '%s' at %s:%d\n", directive.Name, directive.File, directive.Line)
+			continue
+		}
+
+		value := strings.Join(directive.Args, " ")
+		if exists && param.repeatable {
+			if previous := ServerConfig.Get(param.name); repeated[param.name] && previous != "" && value != "" {
+				value = previous + " " + value
+			}
+			repeated[param.name] = true
+		}
+		err := ServerConfig.Set(directive.Name, value)
+		if err != nil {
+			return directive.Error(err)
+		}
+	}
+	return nil
+}
+
+// ParseConfigOptions turns command line options into directives. Every argument starting with `--`
+// begins a new directive, and the arguments following it are its values.
+func ParseConfigOptions(args []string) ([]ConfigDirective, error) {
+	directives := []ConfigDirective{}
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			name := strings.ToLower(strings.TrimPrefix(arg, "--"))
+			if name == "" {
+				return nil, errors.New("invalid empty option name")
+			}
+			directives = append(directives, ConfigDirective{Name: name, Args: []string{}, Line: i + 1})
+			continue
+		}
+		if len(directives) == 0 {
+			return nil, fmt.Errorf("invalid option '%s', options must start with --", arg)
+		}
+		last := &directives[len(directives)-1]
+		last.Args = append(last.Args, arg)
+	}
+	return directives, nil
+}
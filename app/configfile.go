@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maximum depth of nested include directives, to stop include cycles
+const CONFIG_MAX_INCLUDE_DEPTH = 16
+
+// ConfigDirective is a single line of a configuration file, or a single command line option
+type ConfigDirective struct {
+	Name string
+	Args []string
+	// file the directive was read from, empty for command line options
+	File string
+	Line int
+}
+
+func (d ConfigDirective) Error(err error) error {
+	if d.File == "" {
+		return fmt.Errorf("bad option --%s: %v", d.Name, err)
+	}
+	return fmt.Errorf("bad directive '%s' at %s:%d: %v", d.Name, d.File, d.Line, err)
+}
+
+// ParseConfigFile reads the directives of a redis.conf style file, expanding include directives
+func ParseConfigFile(path string) ([]ConfigDirective, error) {
+	return parseConfigFile(path, 0)
+}
+
+func parseConfigFile(path string, depth int) ([]ConfigDirective, error) {
+	if depth > CONFIG_MAX_INCLUDE_DEPTH {
+		return nil, fmt.Errorf("too many nested includes reading %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+
+	directives := []ConfigDirective{}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		args, err := SplitConfigArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		directive := ConfigDirective{
+			Name: strings.ToLower(args[0]),
+			Args: args[1:],
+			File: path,
+			Line: lineNumber,
+		}
+
+		if directive.Name == CONFIG_INCLUDE {
+			if len(directive.Args) != 1 {
+				return nil, directive.Error(errors.New("wrong number of arguments"))
+			}
+			included, err := parseConfigFile(directive.Args[0], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			directives = append(directives, included...)
+			continue
+		}
+
+		directives = append(directives, directive)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return directives, nil
+}
+
+// SplitConfigArgs splits a configuration line into its arguments. Arguments are separated by spaces
+// and may be quoted. Double quoted arguments support the \n, \r, \t, \b, \a and \xHH escapes, single
+// quoted arguments only support \'.
+func SplitConfigArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		for i < len(line) && isConfigSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var current strings.Builder
+		inDoubleQuotes, inSingleQuotes := false, false
+
+		for done := false; !done; {
+			switch {
+			case inDoubleQuotes:
+				if i >= len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexByte(line[i+2:i+4]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					current.WriteByte(unescapeConfigByte(line[i]))
+				case c == '"':
+					// closing quotes must be followed by a space or nothing at all
+					if i+1 < len(line) && !isConfigSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in configuration line")
+					}
+					done = true
+				default:
+					current.WriteByte(c)
+				}
+			case inSingleQuotes:
+				if i >= len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isConfigSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in configuration line")
+					}
+					done = true
+				default:
+					current.WriteByte(c)
+				}
+			default:
+				if i >= len(line) {
+					done = true
+					break
+				}
+				switch c := line[i]; c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, current.String())
+	}
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == 0
+}
+
+func isHexByte(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 8)
+	return err == nil
+}
+
+func unescapeConfigByte(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
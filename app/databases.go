@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_DATABASES = 16
+
+// FLUSHDB and FLUSHALL modes
+const (
+	FLUSH_ASYNC = "ASYNC"
+	FLUSH_SYNC  = "SYNC"
+)
+
+var (
+	ErrDBIndexOutOfRange = errors.New("DB index is out of range")
+	ErrNotInteger        = errors.New("value is not an integer or out of range")
+	ErrSameObject        = errors.New("source and destination objects are the same")
+)
+
+// Databases holds the logical keyspaces clients pick with SELECT. Clients only remember the index
+// of their database and look it up on every command, so SWAPDB swaps two entries and every client
+// sees the swap right away.
+type Databases struct {
+	mu  sync.RWMutex
+	dbs []*ServerMemory
+}
+
+var ServerDatabases = NewDatabases(DEFAULT_DATABASES)
+
+func NewDatabases(count int) *Databases {
+	d := &Databases{dbs: make([]*ServerMemory, count)}
+	for i := range d.dbs {
+		d.dbs[i] = NewServerMemory()
+	}
+	return d
+}
+
+// InitDatabases creates the number of databases set by the databases parameter. It must be called
+// before the server starts, since it replaces every database.
+func InitDatabases() error {
+	count := ServerConfig.GetInt(CONFIG_DATABASES)
+	if count < 1 {
+		return errors.New("Invalid number of databases")
+	}
+	ServerDatabases = NewDatabases(count)
+	return nil
+}
+
+// DB returns the database at index, which must be in range
+func (d *Databases) DB(index int) *ServerMemory {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.dbs[index]
+}
+
+func (d *Databases) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.dbs)
+}
+
+// All returns every database, ordered by index
+func (d *Databases) All() []*ServerMemory {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]*ServerMemory{}, d.dbs...)
+}
+
+// Index parses a database index argument and checks that it is in range
+func (d *Databases) Index(arg string) (int, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if index < 0 || index >= d.Len() {
+		return 0, ErrDBIndexOutOfRange
+	}
+	return index, nil
+}
+
+// Swap exchanges the contents of two databases
+func (d *Databases) Swap(a, b int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dbs[a], d.dbs[b] = d.dbs[b], d.dbs[a]
+}
+
+// FlushAll removes every key of every database, returning the number of keys removed
+func (d *Databases) FlushAll() int {
+	removed := 0
+	for _, db := range d.All() {
+		removed += db.Flush()
+	}
+	return removed
+}
+
+// ExecuteSelect runs SELECT index
+func ExecuteSelect(args []string, client *Client) (string, error) {
+	index, err := ServerDatabases.Index(args[0])
+	if err != nil {
+		return ToRespError(err), nil
+	}
+	client.SelectDB(index)
+	return ToRespSimpleString(OK), nil
+}
+
+// ExecuteMove runs MOVE key db. The key is only moved when it does not exist in the target database.
+func ExecuteMove(args []string, client *Client) (string, error) {
+	key := args[0]
+	index, err := ServerDatabases.Index(args[1])
+	if err != nil {
+		return ToRespError(err), nil
+	}
+	if index == client.DB() {
+		return ToRespError(ErrSameObject), nil
+	}
+
+	if client.Database().Move(key, ServerDatabases.DB(index), client.expired) {
+		return ToRespInteger(1), nil
+	}
+	return ToRespInteger(0), nil
+}
+
+// ExecuteSwapDB runs SWAPDB index1 index2
+func ExecuteSwapDB(args []string) (string, error) {
+	first, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ToRespError(errors.New("invalid first DB index")), nil
+	}
+	second, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ToRespError(errors.New("invalid second DB index")), nil
+	}
+	count := ServerDatabases.Len()
+	if first < 0 || first >= count || second < 0 || second >= count {
+		return ToRespError(ErrDBIndexOutOfRange), nil
+	}
+
+	if first != second {
+		ServerDatabases.Swap(first, second)
+	}
+	return ToRespSimpleString(OK), nil
+}
+
+// ExecuteFlush runs FLUSHDB [ASYNC | SYNC] on the client database, or FLUSHALL [ASYNC | SYNC] when all
+// is set. Flushed keyspaces are dropped at once and reclaimed by the garbage collector, so both modes
+// return right away.
+func ExecuteFlush(args []string, client *Client, all bool) (string, error) {
+	if len(args) > 1 {
+		return ToRespError(errors.New("syntax error")), nil
+	}
+	if len(args) == 1 {
+		mode := strings.ToUpper(args[0])
+		if mode != FLUSH_ASYNC && mode != FLUSH_SYNC {
+			return ToRespError(errors.New("syntax error")), nil
+		}
+	}
+
+	if all {
+		ServerDatabases.FlushAll()
+	} else {
+		client.Database().Flush()
+	}
+	return ToRespSimpleString(OK), nil
+}
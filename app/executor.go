@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Command execution modes
+const (
+	// every connection goroutine runs its commands directly against the keyspace
+	EXECUTOR_DIRECT = "direct"
+	// connection goroutines only parse requests and write replies, commands run on a single goroutine
+	EXECUTOR_SINGLE = "single"
+)
+
+const EXECUTOR_QUEUE_SIZE = 1024
+
+// CommandExecutor runs every command when the server uses the single executor mode. It is nil
+// in direct mode.
+var CommandExecutor *Executor
+
+// Executor applies jobs one at a time on its own goroutine, in the order they were submitted.
+type Executor struct {
+	jobs chan executorJob
+}
+
+type executorJob struct {
+	run  func()
+	done chan struct{}
+}
+
+func NewExecutor() *Executor {
+	e := &Executor{jobs: make(chan executorJob, EXECUTOR_QUEUE_SIZE)}
+	go e.loop()
+	return e
+}
+
+func (e *Executor) loop() {
+	for job := range e.jobs {
+		job.run()
+		close(job.done)
+	}
+}
+
+// Run queues fn and blocks until the executor has run it
+func (e *Executor) Run(fn func()) {
+	done := make(chan struct{})
+	e.jobs <- executorJob{fn, done}
+	<-done
+}
+
+// bufferedConn collects the replies written by a command running on the executor, so they can be
+// written to the client by the connection goroutine instead of blocking the executor on network I/O.
+type bufferedConn struct {
+	net.Conn
+	replies bytes.Buffer
+}
+
+func (b *bufferedConn) Write(p []byte) (int, error) {
+	return b.replies.Write(p)
+}
+
+// DispatchCommand runs the command either directly or on the CommandExecutor, depending on the
+// execution mode. Commands which block waiting on other connections, or which take over the
+// connection, always run directly so they cannot stall the executor. Unknown commands, calls with a
+// wrong number of arguments, from clients which have not authenticated yet, denied by the ACL, or
+// which may grow the dataset while over maxmemory are rejected before running. A rejection between
+// MULTI and EXEC also makes the EXEC fail.
+func DispatchCommand(server RedisServer, cmp CommandComponents, client *Client) error {
+	reject := func(reply string) error {
+		client.Transaction.Abort()
+		_, writeErr := client.Write([]byte(reply))
+		return writeErr
+	}
+
+	respCommand, exists := RespCommands[cmp.Command]
+	if !exists {
+		return reject(ToRespError(unknownCommandError(cmp)))
+	}
+	if !respCommand.CheckArity(len(cmp.Args) + 1) {
+		Stats.RecordRejectedCommand(cmp.Command)
+		err := fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmp.Command))
+		return reject(ToRespError(err))
+	}
+
+	if requiresAuth(client, respCommand) {
+		Stats.RecordRejectedCommand(cmp.Command)
+		return reject(ToRespErrorWithCode(NOAUTH, ErrNoAuth))
+	}
+	if reply := checkCommandPermissions(client, respCommand, cmp); reply != "" {
+		Stats.RecordRejectedCommand(cmp.Command)
+		return reject(reply)
+	}
+	if rejectsForMaxMemory(client, respCommand) {
+		Stats.RecordRejectedCommand(cmp.Command)
+		return reject(ToRespErrorWithCode(OOM, ErrOutOfMemory))
+	}
+
+	client.beginCommand(cmp.Command, cmp.Args)
+	defer client.endCommand()
+	loggedArgs := redactArgs(cmp.Command, cmp.Args)
+	if cmp.Command != MONITOR {
+		ServerMonitors.Feed(client.DB(), client.Address(), cmp.Command, loggedArgs)
+	}
+
+	queueLength := len(client.Transaction.Queue)
+	var err error
+	var duration time.Duration
+	if CommandExecutor == nil || runsOutsideExecutor(cmp) {
+		start := time.Now()
+		err = server.RunCommand(cmp, client, client)
+		duration = time.Since(start)
+	} else {
+		buffered := &bufferedConn{Conn: client}
+		CommandExecutor.Run(func() {
+			start := time.Now()
+			err = server.RunCommand(cmp, buffered, client)
+			duration = time.Since(start)
+		})
+
+		if buffered.replies.Len() > 0 {
+			_, writeErr := client.Write(buffered.replies.Bytes())
+			if writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+	}
+
+	failed := err != nil || client.repliedWithError()
+	if cmp.Command == MONITOR && !failed {
+		// monitor lines are written to the connection directly, they are not replies to the monitor's own commands
+		ServerMonitors.Add(client.Conn)
+		client.monitor.Store(true)
+	}
+	Stats.RecordCommand(cmp.Command, duration, failed)
+	// commands queued by MULTI are logged when EXEC runs them
+	queued := len(client.Transaction.Queue) > queueLength
+	if !queued && !slices.Contains(respCommand.flags, "skip_slowlog") {
+		ServerSlowLog.Record(cmp.Command, loggedArgs, duration, client.Address(), client.Name())
+	}
+	if slices.Contains(respCommand.flags, "fast") {
+		ServerLatencyMonitor.Sample(LATENCY_EVENT_FAST_COMMAND, duration)
+	} else {
+		ServerLatencyMonitor.Sample(LATENCY_EVENT_COMMAND, duration)
+	}
+	return err
+}
+
+// unknownCommandError describes a command the server does not support, along with its first arguments
+func unknownCommandError(cmp CommandComponents) error {
+	args := ""
+	for _, arg := range cmp.Args {
+		args += "'" + arg + "' "
+	}
+	return fmt.Errorf("unknown command '%s', with args beginning with: %s", strings.ToLower(cmp.Command), args)
+}
+
+// RunOnExecutor runs fn on the CommandExecutor, or directly when the server runs in direct mode
+func RunOnExecutor(fn func()) {
+	if CommandExecutor == nil {
+		fn()
+		return
+	}
+	CommandExecutor.Run(fn)
+}
+
+func runsOutsideExecutor(cmp CommandComponents) bool {
+	switch cmp.Command {
+	case PSYNC, WAIT, REPLCONF, SHUTDOWN:
+		return true
+	case XREAD:
+		return len(cmp.Args) > 0 && strings.ToUpper(cmp.Args[0]) == "BLOCK"
+	default:
+		return false
+	}
+}
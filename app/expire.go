@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXPIRE conditions
+const (
+	EXPIRE_NX = "NX"
+	EXPIRE_XX = "XX"
+	EXPIRE_GT = "GT"
+	EXPIRE_LT = "LT"
+)
+
+// TTL replies of keys without a time to live
+const (
+	TTL_NO_EXPIRY   = -1
+	TTL_MISSING_KEY = -2
+)
+
+// ExecuteExpire runs EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT key time [NX | XX | GT | LT]. unit is
+// the unit of time, and absolute tells whether it is a unix time or relative to now. The new expiry
+// is propagated as PEXPIREAT, so replicas expire the key at the same time whatever their clock, and
+// an expiry which already passed deletes the key and is propagated as DEL.
+func ExecuteExpire(command string, args []string, client *Client, unit time.Duration, absolute bool) (string, error) {
+	key := args[0]
+	conditions, err := parseExpireConditions(args[2:])
+	if err != nil {
+		return ToRespError(err), nil
+	}
+	expires, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ToRespError(ErrNotInteger), nil
+	}
+
+	invalidTime := fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(command))
+	factor := int64(unit / time.Millisecond)
+	if expires > math.MaxInt64/factor || expires < math.MinInt64/factor {
+		return ToRespError(invalidTime), nil
+	}
+	expires *= factor
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if expires > math.MaxInt64-now {
+			return ToRespError(invalidTime), nil
+		}
+		expires += now
+	}
+
+	updated, deleted := false, false
+	client.Database().Atomic([]string{key}, func(locked *LockedKeys) {
+		item, exists := locked.Get(key)
+		if !exists || client.expired(item) || !conditions.met(item.expires, expires) {
+			return
+		}
+		updated = true
+		// the master of a replica sends a DEL once the key expires on its own clock
+		if expires <= time.Now().UnixMilli() && client.Type() != CLIENT_TYPE_MASTER {
+			locked.Delete(key)
+			deleted = true
+			return
+		}
+		locked.Set(key, MemoryItem{item.value, expires})
+	})
+
+	switch {
+	case !updated:
+		client.rewriteCommand()
+		return ToRespInteger(0), nil
+	case deleted:
+		client.rewriteCommand(DEL, key)
+	default:
+		client.rewriteCommand(PEXPIREAT, key, strconv.FormatInt(expires, 10))
+	}
+	return ToRespInteger(1), nil
+}
+
+// expireConditions are the conditions under which EXPIRE replaces the expiry of a key. XX can be
+// combined with GT or LT.
+type expireConditions struct {
+	nx, xx, gt, lt bool
+}
+
+func parseExpireConditions(args []string) (expireConditions, error) {
+	conditions := expireConditions{}
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case EXPIRE_NX:
+			conditions.nx = true
+		case EXPIRE_XX:
+			conditions.xx = true
+		case EXPIRE_GT:
+			conditions.gt = true
+		case EXPIRE_LT:
+			conditions.lt = true
+		default:
+			return conditions, fmt.Errorf("Unsupported option %s", arg)
+		}
+	}
+	if conditions.nx && (conditions.xx || conditions.gt || conditions.lt) {
+		return conditions, errors.New("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if conditions.gt && conditions.lt {
+		return conditions, errors.New("GT and LT options at the same time are not compatible")
+	}
+	return conditions, nil
+}
+
+// met reports whether expires can replace the current expiry, 0 when the key has none. Keys without
+// an expiry behave as if their time to live was infinite.
+func (c expireConditions) met(current, expires int64) bool {
+	switch {
+	case c.nx && current != 0:
+		return false
+	case c.xx && current == 0:
+		return false
+	case c.gt && (current == 0 || expires <= current):
+		return false
+	case c.lt && current != 0 && expires >= current:
+		return false
+	}
+	return true
+}
+
+// ExecuteTTL runs TTL, PTTL, EXPIRETIME and PEXPIRETIME key. unit is the unit of the reply, and
+// absolute tells whether to return the unix time of the expiry instead of the time left.
+func ExecuteTTL(args []string, client *Client, unit time.Duration, absolute bool) (string, error) {
+	item, exists := client.Database().Get(args[0])
+	if !exists || item.Expired() {
+		return ToRespInteger(TTL_MISSING_KEY), nil
+	}
+	if item.expires == 0 {
+		return ToRespInteger(TTL_NO_EXPIRY), nil
+	}
+
+	factor := int64(unit / time.Millisecond)
+	if absolute {
+		return ToRespInteger(int(item.expires / factor)), nil
+	}
+	left := max(item.expires-time.Now().UnixMilli(), 0)
+	// times left in seconds are rounded to the closest second
+	return ToRespInteger(int((left + factor/2) / factor)), nil
+}
+
+// ExecutePersist runs PERSIST key, which removes the expiry of the key
+func ExecutePersist(args []string, client *Client) (string, error) {
+	key := args[0]
+	persisted := false
+	client.Database().Update(key, func(item MemoryItem, exists bool) (MemoryItem, bool) {
+		if !exists || client.expired(item) || item.expires == 0 {
+			return item, false
+		}
+		persisted = true
+		return MemoryItem{item.value, 0}, true
+	})
+
+	if !persisted {
+		client.rewriteCommand()
+		return ToRespInteger(0), nil
+	}
+	return ToRespInteger(1), nil
+}
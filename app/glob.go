@@ -0,0 +1,122 @@
+package main
+
+// GlobMatch reports whether s matches the Redis-style glob pattern. It supports `*`, `?`,
+// character classes such as `[abc]`, `[^a]` and `[a-z]`, and backslash escapes. KEYS, SCAN MATCH,
+// ACL key patterns, CONFIG GET and COMMAND LIST FILTERBY PATTERN all match through it.
+func GlobMatch(pattern, s string, nocase bool) bool {
+	skipLongerMatches := false
+	return globMatch([]byte(pattern), []byte(s), nocase, &skipLongerMatches, 0)
+}
+
+// globMatch matches s against pattern. skipLongerMatches is set once the rest of the pattern after a
+// `*` matched nowhere in the rest of s: a longer match for an earlier `*` would only leave less of s
+// to match, so the earlier stars give up right away instead of backtracking exponentially.
+func globMatch(pattern, s []byte, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	// protect against pathological patterns such as `*****...*a`
+	if nesting > 1000 {
+		return false
+	}
+
+	for len(pattern) > 0 && len(s) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(s) > 0 {
+				if globMatch(pattern[1:], s, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				s = s[1:]
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if equalFoldByte(pattern[0], s[0], nocase) {
+						match = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					c := s[0]
+					if nocase {
+						start, end, c = lowerByte(start), lowerByte(end), lowerByte(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				default:
+					if equalFoldByte(pattern[0], s[0], nocase) {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			// an unterminated class is treated as if it was closed at the end of the pattern
+			if len(pattern) == 0 {
+				pattern = []byte{']'}
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if !equalFoldByte(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+
+	if len(s) == 0 {
+		for len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(pattern) == 0 && len(s) == 0
+}
+
+func equalFoldByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lowerByte(a) == lowerByte(b)
+	}
+	return a == b
+}
+
+func lowerByte(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + ('a' - 'A')
+	}
+	return b
+}
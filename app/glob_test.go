@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		nocase  bool
+		want    bool
+	}{
+		// wildcards
+		{"*", "", false, true},
+		{"**", "anything", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "heeeello", false, true},
+		{"user:*:name", "user:42:name", false, true},
+		{"user:*:name", "user:42:email", false, false},
+
+		// escaped metacharacters match themselves only
+		{`\*`, "*", false, true},
+		{`\*`, "a", false, false},
+		{`a\?b`, "a?b", false, true},
+		{`a\?b`, "axb", false, false},
+		{`\[abc]`, "[abc]", false, true},
+		{`\[abc]`, "a", false, false},
+		{`\\`, `\`, false, true},
+		// a trailing backslash is a literal backslash
+		{`a\`, `a\`, false, true},
+
+		// classes
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{`[\]]`, "]", false, true},
+		{"[a-c]", "b", false, true},
+		{"[a-c]", "d", false, false},
+		// ranges written in reverse order are swapped
+		{"[z-a]", "m", false, true},
+		{"[^z-a]", "m", false, false},
+		{"[^z-a]", "A", false, true},
+		// the ] closing [a-] is taken as the end of the range ]-a, which leaves the class unterminated
+		{"[a-]", "a", false, true},
+		{"[a-]", "_", false, true},
+		{"[a-]", "-", false, false},
+		{"[a-]", "b", false, false},
+		// an unterminated class runs to the end of the pattern
+		{"[abc", "b", false, true},
+		{"[abc", "[abc", false, false},
+		{"[", "[", false, false},
+		{"[", "", false, false},
+
+		// case folding
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"[A-C]x", "bX", true, true},
+		{"[^A-C]", "b", true, false},
+
+		// many stars
+		{"*a*b*c*d*e*", "xaxbxcxdxex", false, true},
+		{"*a*b*c*d*e*", "xaxbxcxdx", false, false},
+		{"a*b*c", "abc", false, true},
+		{"a*b*c", "acb", false, false},
+	}
+
+	for _, test := range tests {
+		if got := GlobMatch(test.pattern, test.s, test.nocase); got != test.want {
+			t.Errorf("GlobMatch(%q, %q, %v) = %v, want %v", test.pattern, test.s, test.nocase, got, test.want)
+		}
+	}
+}
+
+func TestGlobMatchGivesUpOnPathologicalPatterns(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	s := strings.Repeat("a", 60)
+
+	start := time.Now()
+	if GlobMatch(pattern, s, false) {
+		t.Fatalf("%q matched %q", pattern, s)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("matching took %v, longer matches of earlier stars are not skipped", elapsed)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "math/bits"
+
+// Latency histograms use a log-linear layout: every power of two range is split into
+// HISTOGRAM_SUB_BUCKETS equal buckets, so recorded values keep about two significant digits.
+const (
+	HISTOGRAM_SUB_BUCKET_BITS = 4
+	HISTOGRAM_SUB_BUCKETS     = 1 << HISTOGRAM_SUB_BUCKET_BITS
+	// largest tracked exponent, values above 2^HISTOGRAM_MAX_EXPONENT microseconds are clamped
+	HISTOGRAM_MAX_EXPONENT = 40
+	HISTOGRAM_BUCKETS      = (HISTOGRAM_MAX_EXPONENT - HISTOGRAM_SUB_BUCKET_BITS + 2) * HISTOGRAM_SUB_BUCKETS
+)
+
+// LatencyHistogram counts latencies in microseconds. It is not safe for concurrent use.
+type LatencyHistogram struct {
+	counts [HISTOGRAM_BUCKETS]int64
+	total  int64
+}
+
+func histogramBucket(value int64) int {
+	if value < HISTOGRAM_SUB_BUCKETS {
+		if value < 0 {
+			return 0
+		}
+		return int(value)
+	}
+	exponent := bits.Len64(uint64(value)) - 1
+	if exponent > HISTOGRAM_MAX_EXPONENT {
+		return HISTOGRAM_BUCKETS - 1
+	}
+	shift := exponent - HISTOGRAM_SUB_BUCKET_BITS
+	subBucket := int(value>>shift) & (HISTOGRAM_SUB_BUCKETS - 1)
+	return (shift+1)*HISTOGRAM_SUB_BUCKETS + subBucket
+}
+
+// histogramBucketLimit returns the highest value counted by the bucket
+func histogramBucketLimit(bucket int) int64 {
+	if bucket < HISTOGRAM_SUB_BUCKETS {
+		return int64(bucket)
+	}
+	shift := bucket/HISTOGRAM_SUB_BUCKETS - 1
+	subBucket := int64(bucket%HISTOGRAM_SUB_BUCKETS) | HISTOGRAM_SUB_BUCKETS
+	return (subBucket+1)<<shift - 1
+}
+
+func (h *LatencyHistogram) Record(usec int64) {
+	h.counts[histogramBucket(usec)]++
+	h.total++
+}
+
+// Percentile returns the latency below which the given percentage of the recorded values fall
+func (h *LatencyHistogram) Percentile(percentile float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(float64(h.total)*percentile/100 + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	seen := int64(0)
+	for bucket, count := range h.counts {
+		seen += count
+		if seen >= target {
+			return histogramBucketLimit(bucket)
+		}
+	}
+	return histogramBucketLimit(HISTOGRAM_BUCKETS - 1)
+}
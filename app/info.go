@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const REDIS_VERSION = "7.2.0"
+
+// INFO sections
+const (
+	INFO_SERVER       = "server"
+	INFO_CLIENTS      = "clients"
+	INFO_MEMORY       = "memory"
+	INFO_PERSISTENCE  = "persistence"
+	INFO_STATS        = "stats"
+	INFO_REPLICATION  = REPLICATION
+	INFO_COMMANDSTATS = "commandstats"
+	INFO_LATENCYSTATS = "latencystats"
+	INFO_KEYSPACE     = "keyspace"
+)
+
+// INFO arguments selecting several sections
+const (
+	INFO_DEFAULT    = "default"
+	INFO_ALL        = "all"
+	INFO_EVERYTHING = "everything"
+)
+
+type infoSection struct {
+	name string
+	// default sections are returned by INFO without arguments
+	isDefault bool
+	fields    func(server RedisServer) []string
+}
+
+// infoSections lists the sections in the order INFO returns them
+var infoSections []infoSection
+
+func init() {
+	infoSections = []infoSection{
+		{INFO_SERVER, true, serverInfo},
+		{INFO_CLIENTS, true, clientsInfo},
+		{INFO_MEMORY, true, memoryInfo},
+		{INFO_PERSISTENCE, true, persistenceInfo},
+		{INFO_STATS, true, statsInfo},
+		{INFO_REPLICATION, true, func(server RedisServer) []string { return server.ReplicationInfo() }},
+		{INFO_COMMANDSTATS, false, commandStatsInfo},
+		{INFO_LATENCYSTATS, false, latencyStatsInfo},
+		{INFO_KEYSPACE, true, keyspaceInfo},
+	}
+}
+
+// GenerateInfo builds the INFO reply for the requested sections. Unknown sections are ignored.
+func GenerateInfo(server RedisServer, args []string) string {
+	requested := map[string]bool{}
+	all, everything := false, false
+	if len(args) == 0 {
+		requested[INFO_DEFAULT] = true
+	}
+	for _, arg := range args {
+		switch section := strings.ToLower(arg); section {
+		case INFO_ALL:
+			all = true
+		case INFO_EVERYTHING:
+			everything = true
+		default:
+			requested[section] = true
+		}
+	}
+
+	sections := []string{}
+	for _, section := range infoSections {
+		included := requested[section.name] || everything || all ||
+			(requested[INFO_DEFAULT] && section.isDefault)
+		if !included {
+			continue
+		}
+		lines := append([]string{"# " + CapitalizeFirstCharacter(section.name)}, section.fields(server)...)
+		sections = append(sections, strings.Join(lines, "\r\n"))
+	}
+
+	return strings.Join(sections, "\r\n\r\n") + "\r\n"
+}
+
+func serverInfo(server RedisServer) []string {
+	now := time.Now()
+	uptime := now.Sub(serverStartTime)
+	executable, _ := os.Executable()
+	return []string{
+		"redis_version:" + REDIS_VERSION,
+		"redis_mode:standalone",
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("arch_bits:%d", 32<<(^uint(0)>>63)),
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"executor:" + ServerConfig.Get(CONFIG_EXECUTOR),
+		"tcp_port:" + ServerConfig.Get(CONFIG_PORT),
+		fmt.Sprintf("server_time_usec:%d", now.UnixMicro()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+		"executable:" + executable,
+		"config_file:" + ServerConfig.File(),
+	}
+}
+
+func clientsInfo(server RedisServer) []string {
+	return []string{
+		fmt.Sprintf("connected_clients:%d", ServerClients.Len()-ServerClients.CountByType(CLIENT_TYPE_REPLICA)),
+		fmt.Sprintf("blocked_clients:%d", Stats.BlockedClients.Load()),
+		"maxclients:" + ServerConfig.Get(CONFIG_MAXCLIENTS),
+	}
+}
+
+func memoryInfo(server RedisServer) []string {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	used := memStats.HeapAlloc
+	peak := Stats.UsedMemoryPeak.Load()
+	for used > peak && !Stats.UsedMemoryPeak.CompareAndSwap(peak, used) {
+		peak = Stats.UsedMemoryPeak.Load()
+	}
+	if used > peak {
+		peak = used
+	}
+	maxMemory := uint64(ServerConfig.GetInt(CONFIG_MAXMEMORY))
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		"used_memory_human:" + BytesToHuman(used),
+		fmt.Sprintf("used_memory_rss:%d", memStats.Sys),
+		"used_memory_rss_human:" + BytesToHuman(memStats.Sys),
+		fmt.Sprintf("used_memory_peak:%d", peak),
+		"used_memory_peak_human:" + BytesToHuman(peak),
+		fmt.Sprintf("maxmemory:%d", maxMemory),
+		"maxmemory_human:" + BytesToHuman(maxMemory),
+		"maxmemory_policy:" + MAXMEMORY_POLICY_NOEVICTION,
+		"mem_allocator:go",
+	}
+}
+
+func persistenceInfo(server RedisServer) []string {
+	rdbStatus := "ok"
+	if Stats.LastSaveFailed.Load() {
+		rdbStatus = "err"
+	}
+	aofEnabled := 0
+	if AppendOnlyEnabled() {
+		aofEnabled = 1
+	}
+	aofStatus := "ok"
+	if Stats.AofLastWriteFailed.Load() {
+		aofStatus = "err"
+	}
+
+	return []string{
+		"loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", Stats.Dirty.Load()),
+		"rdb_bgsave_in_progress:0",
+		fmt.Sprintf("rdb_last_save_time:%d", Stats.LastSaveTime.Load()),
+		"rdb_last_bgsave_status:" + rdbStatus,
+		fmt.Sprintf("aof_enabled:%d", aofEnabled),
+		"aof_rewrite_in_progress:0",
+		"aof_last_write_status:" + aofStatus,
+	}
+}
+
+func statsInfo(server RedisServer) []string {
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", Stats.TotalConnectionsReceived.Load()),
+		fmt.Sprintf("total_commands_processed:%d", Stats.TotalCommandsProcessed.Load()),
+		fmt.Sprintf("rejected_connections:%d", Stats.RejectedConnections.Load()),
+		fmt.Sprintf("expired_keys:%d", Stats.ExpiredKeys.Load()),
+		fmt.Sprintf("expired_stale_perc:%.2f", Stats.ExpiredStalePerc()),
+		fmt.Sprintf("expired_time_cap_reached_count:%d", Stats.ExpiredTimeCapReached.Load()),
+		fmt.Sprintf("keyspace_hits:%d", Stats.KeyspaceHits.Load()),
+		fmt.Sprintf("keyspace_misses:%d", Stats.KeyspaceMisses.Load()),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", Stats.ClientOutputLimitDisconnections.Load()),
+	}
+}
+
+func commandStatsInfo(server RedisServer) []string {
+	names, stats := Stats.CommandStats()
+	lines := []string{}
+	for i, name := range names {
+		usecPerCall := 0.0
+		if stats[i].Calls > 0 {
+			usecPerCall = float64(stats[i].Usec) / float64(stats[i].Calls)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			name, stats[i].Calls, stats[i].Usec, usecPerCall, stats[i].RejectedCalls, stats[i].FailedCalls))
+	}
+	return lines
+}
+
+func latencyStatsInfo(server RedisServer) []string {
+	percentiles, _ := ParsePercentiles(ServerConfig.Get(CONFIG_LATENCY_TRACKING_PERCENTILES))
+	names, stats := Stats.CommandStats()
+	lines := []string{}
+	for i, name := range names {
+		if stats[i].Latency == nil || len(percentiles) == 0 {
+			continue
+		}
+		values := []string{}
+		for _, percentile := range percentiles {
+			values = append(values, fmt.Sprintf("p%s=%.3f",
+				strconv.FormatFloat(percentile, 'f', -1, 64), float64(stats[i].Latency.Percentile(percentile))))
+		}
+		lines = append(lines, fmt.Sprintf("latency_percentiles_usec_%s:%s", name, strings.Join(values, ",")))
+	}
+	return lines
+}
+
+func keyspaceInfo(server RedisServer) []string {
+	lines := []string{}
+	for i, db := range ServerDatabases.All() {
+		keys := db.Len()
+		if keys == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0", i, keys, db.ExpiresLen()))
+	}
+	return lines
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// COPY options
+const (
+	COPY_DB      = "DB"
+	COPY_REPLACE = "REPLACE"
+)
+
+var ErrNoSuchKey = errors.New("no such key")
+
+// ExecuteDel runs DEL and UNLINK, returning the number of keys removed. Keys which already expired
+// are removed too but not counted. Removing a key never walks its value: the garbage collector
+// reclaims it on its own goroutines, so even large values are unlinked right away by both commands.
+func ExecuteDel(args []string, client *Client) (string, error) {
+	removed := 0
+	client.Database().Atomic(args, func(locked *LockedKeys) {
+		for _, key := range args {
+			item, exists := locked.Get(key)
+			if !exists {
+				continue
+			}
+			locked.Delete(key)
+			if !client.expired(item) {
+				removed++
+			}
+		}
+	})
+	return ToRespInteger(removed), nil
+}
+
+// ExecuteExists runs EXISTS and TOUCH, returning the number of keys which exist. Keys given several
+// times are counted every time.
+func ExecuteExists(args []string, client *Client) (string, error) {
+	db := client.Database()
+	count := 0
+	for _, key := range args {
+		if item, exists := db.Get(key); exists && !item.Expired() {
+			count++
+		}
+	}
+	return ToRespInteger(count), nil
+}
+
+// ExecuteRename runs RENAME key newkey, or RENAMENX key newkey when nx is set. The value keeps its
+// type and expiry, and replaces whatever newkey held unless nx is set.
+func ExecuteRename(args []string, client *Client, nx bool) (string, error) {
+	key, newKey := args[0], args[1]
+	reply := ""
+	client.Database().Atomic([]string{key, newKey}, func(locked *LockedKeys) {
+		item, exists := locked.Get(key)
+		if !exists || client.expired(item) {
+			reply = ToRespError(ErrNoSuchKey)
+			return
+		}
+		if nx {
+			if newItem, exists := locked.Get(newKey); key == newKey || (exists && !client.expired(newItem)) {
+				reply = ToRespInteger(0)
+				return
+			}
+		}
+
+		locked.Delete(key)
+		locked.Set(newKey, item)
+		if nx {
+			reply = ToRespInteger(1)
+		} else {
+			reply = ToRespSimpleString(OK)
+		}
+	})
+	return reply, nil
+}
+
+// ExecuteCopy runs COPY source destination [DB destination-db] [REPLACE]
+func ExecuteCopy(args []string, client *Client) (string, error) {
+	source, destination := args[0], args[1]
+	dbIndex := client.DB()
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case COPY_DB:
+			if i+1 >= len(args) {
+				return ToRespError(errors.New("syntax error")), nil
+			}
+			i++
+			index, err := ServerDatabases.Index(args[i])
+			if err != nil {
+				return ToRespError(err), nil
+			}
+			dbIndex = index
+		case COPY_REPLACE:
+			replace = true
+		default:
+			return ToRespError(errors.New("syntax error")), nil
+		}
+	}
+	if dbIndex == client.DB() && source == destination {
+		return ToRespError(ErrSameObject), nil
+	}
+
+	if client.Database().Copy(source, ServerDatabases.DB(dbIndex), destination, replace, client.expired) {
+		return ToRespInteger(1), nil
+	}
+	return ToRespInteger(0), nil
+}
+
+// ExecuteKeys runs KEYS pattern, returning the keys of the database which match the glob pattern.
+// Expired keys are skipped.
+func ExecuteKeys(args []string, client *Client) (string, error) {
+	pattern := args[0]
+	_, keys := client.Database().Scan(0, math.MaxInt, func(key string, item MemoryItem) bool {
+		return !item.Expired() && GlobMatch(pattern, key, false)
+	})
+	return ToRespBulkStringArray(keys...), nil
+}
+
+// ExecuteRandomKey runs RANDOMKEY
+func ExecuteRandomKey(client *Client) (string, error) {
+	key, exists := client.Database().RandomKey()
+	if !exists {
+		return NULL_BULK_STRING, nil
+	}
+	return ToRespBulkString(key), nil
+}
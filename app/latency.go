@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LATENCY subcommands
+const (
+	LATENCY_LATEST  = "LATEST"
+	LATENCY_HISTORY = "HISTORY"
+	LATENCY_RESET   = "RESET"
+	LATENCY_DOCTOR  = "DOCTOR"
+)
+
+// Latency events sampled by the latency monitor
+const (
+	LATENCY_EVENT_COMMAND            = "command"
+	LATENCY_EVENT_FAST_COMMAND       = "fast-command"
+	LATENCY_EVENT_RDB_LOAD           = "rdb-load"
+	LATENCY_EVENT_RDB_SAVE           = "rdb-save"
+	LATENCY_EVENT_AOF_FSYNC_ALWAYS   = "aof-fsync-always"
+	LATENCY_EVENT_AOF_FSYNC_EVERYSEC = "aof-fsync-everysec"
+	LATENCY_EVENT_EXPIRE_CYCLE       = "expire-cycle"
+)
+
+// number of samples kept for every event
+const LATENCY_HISTORY_LEN = 160
+
+type latencySample struct {
+	// unix time in seconds
+	time int64
+	// latency in milliseconds
+	latency int64
+}
+
+type latencyEvent struct {
+	// samples are kept in a ring buffer, next is the position of the next sample
+	samples []latencySample
+	next    int
+	max     int64
+}
+
+// LatencyMonitor keeps the latency spikes of every event above latency-monitor-threshold
+type LatencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+var ServerLatencyMonitor = &LatencyMonitor{events: map[string]*latencyEvent{}}
+
+// Sample records the latency of event if the latency monitor is enabled and it is above the
+// threshold. Samples taken within the same second are merged, keeping the highest latency.
+func (m *LatencyMonitor) Sample(event string, duration time.Duration) {
+	threshold := ServerConfig.GetInt(CONFIG_LATENCY_MONITOR_THRESHOLD)
+	latency := duration.Milliseconds()
+	if threshold <= 0 || latency < int64(threshold) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, exists := m.events[event]
+	if !exists {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	if latency > e.max {
+		e.max = latency
+	}
+
+	now := time.Now().Unix()
+	if len(e.samples) > 0 {
+		previous := (e.next - 1 + len(e.samples)) % len(e.samples)
+		if e.samples[previous].time == now {
+			e.samples[previous].latency = max(e.samples[previous].latency, latency)
+			return
+		}
+	}
+
+	sample := latencySample{now, latency}
+	if len(e.samples) < LATENCY_HISTORY_LEN {
+		e.samples = append(e.samples, sample)
+		e.next = len(e.samples) % LATENCY_HISTORY_LEN
+		return
+	}
+	e.samples[e.next] = sample
+	e.next = (e.next + 1) % LATENCY_HISTORY_LEN
+}
+
+// SampleSince records the latency of event since start
+func (m *LatencyMonitor) SampleSince(event string, start time.Time) {
+	m.Sample(event, time.Since(start))
+}
+
+// history returns the samples of event from the oldest to the newest
+func (e *latencyEvent) history() []latencySample {
+	if len(e.samples) < LATENCY_HISTORY_LEN {
+		return append([]latencySample{}, e.samples...)
+	}
+	return append(append([]latencySample{}, e.samples[e.next:]...), e.samples[:e.next]...)
+}
+
+func (m *LatencyMonitor) sortedEvents() []string {
+	names := []string{}
+	for name := range m.events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *LatencyMonitor) Latest() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := []string{}
+	for _, name := range m.sortedEvents() {
+		e := m.events[name]
+		history := e.history()
+		latest := history[len(history)-1]
+		items = append(items, ConcatIntoRespArray([]string{
+			ToRespBulkString(name),
+			ToRespInteger(int(latest.time)),
+			ToRespInteger(int(latest.latency)),
+			ToRespInteger(int(e.max)),
+		}))
+	}
+	return ConcatIntoRespArray(items)
+}
+
+func (m *LatencyMonitor) History(event string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, exists := m.events[event]
+	if !exists {
+		return ConcatIntoRespArray([]string{})
+	}
+	items := []string{}
+	for _, sample := range e.history() {
+		items = append(items, ConcatIntoRespArray([]string{
+			ToRespInteger(int(sample.time)),
+			ToRespInteger(int(sample.latency)),
+		}))
+	}
+	return ConcatIntoRespArray(items)
+}
+
+// Reset clears the given events, or every event if none is given, and returns how many were cleared
+func (m *LatencyMonitor) Reset(events []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		count := len(m.events)
+		m.events = map[string]*latencyEvent{}
+		return count
+	}
+	count := 0
+	for _, event := range events {
+		if _, exists := m.events[event]; exists {
+			delete(m.events, event)
+			count++
+		}
+	}
+	return count
+}
+
+// Doctor returns a human readable analysis of the latency spikes observed so far
+func (m *LatencyMonitor) Doctor() string {
+	if ServerConfig.GetInt(CONFIG_LATENCY_MONITOR_THRESHOLD) <= 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.events) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the " +
+			"slightest bit. I honestly think you ought to sleep tonight.\n"
+	}
+
+	report := strings.Builder{}
+	report.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	advices := []string{}
+	for i, name := range m.sortedEvents() {
+		e := m.events[name]
+		history := e.history()
+		total := int64(0)
+		for _, sample := range history {
+			total += sample.latency
+		}
+		average := float64(total) / float64(len(history))
+		deviation := 0.0
+		for _, sample := range history {
+			deviation += math.Abs(float64(sample.latency) - average)
+		}
+		deviation /= float64(len(history))
+		period := int64(0)
+		if len(history) > 1 {
+			period = (history[len(history)-1].time - history[0].time) / int64(len(history)-1)
+		}
+
+		fmt.Fprintf(&report, "%d. %s: %d latency spikes (average %.0fms, mean deviation %.0fms, period %d sec). Worst all time event %dms.\n",
+			i+1, name, len(history), average, deviation, period, e.max)
+		if advice := latencyAdvice(name); advice != "" && !slices.Contains(advices, advice) {
+			advices = append(advices, advice)
+		}
+	}
+
+	if len(advices) > 0 {
+		report.WriteString("\nI have a few advices for you:\n\n")
+		for _, advice := range advices {
+			report.WriteString("- " + advice + "\n")
+		}
+	}
+	return report.String()
+}
+
+func latencyAdvice(event string) string {
+	switch event {
+	case LATENCY_EVENT_COMMAND:
+		return "Check your slow log with SLOWLOG GET to find the slow commands, and avoid commands with a high time complexity, such as XRANGE over large streams, on big values."
+	case LATENCY_EVENT_FAST_COMMAND:
+		return "Fast commands are showing latency spikes. This usually means the server is busy, or that the system is swapping or CPU starved."
+	case LATENCY_EVENT_RDB_LOAD, LATENCY_EVENT_RDB_SAVE:
+		return "Saving and loading the RDB file blocks the server while it runs. Check the speed of the disk holding the dataset."
+	case LATENCY_EVENT_AOF_FSYNC_ALWAYS, LATENCY_EVENT_AOF_FSYNC_EVERYSEC:
+		return "Fsyncing the append only file is slow. Consider setting appendfsync to everysec or no, or check the speed of the disk."
+	case LATENCY_EVENT_EXPIRE_CYCLE:
+		return "Deleting expired keys is slow. Avoid many keys expiring at the same time."
+	default:
+		return ""
+	}
+}
+
+// ExecuteLatency runs the LATENCY subcommands
+func ExecuteLatency(args []string) (string, error) {
+	switch strings.ToUpper(args[0]) {
+	case LATENCY_LATEST:
+		return ServerLatencyMonitor.Latest(), nil
+	case LATENCY_HISTORY:
+		if len(args) != 2 {
+			return ToRespError(fmt.Errorf("wrong number of arguments for 'latency|history' command")), nil
+		}
+		return ServerLatencyMonitor.History(args[1]), nil
+	case LATENCY_RESET:
+		return ToRespInteger(ServerLatencyMonitor.Reset(args[1:])), nil
+	case LATENCY_DOCTOR:
+		return ToRespBulkString(ServerLatencyMonitor.Doctor()), nil
+	default:
+		return ToRespError(fmt.Errorf("unknown subcommand '%s'. Try LATENCY HELP.", args[0])), nil
+	}
+}
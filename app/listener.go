@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Listen opens the listeners of the server: the plain TCP port, unless it is 0, and the TLS port when
+// tls-port is set, on every address of bind, plus the unix socket when unixsocket is set. Both ports
+// are skipped on optional addresses, written -address, which are not available.
+// Connections from every listener are accepted through the returned one.
+func Listen(bind []string, port int) (net.Listener, error) {
+	listeners := []net.Listener{}
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	var tlsConfig *tls.Config
+	tlsPort := ServerConfig.GetInt(CONFIG_TLS_PORT)
+	if tlsPort != 0 {
+		var err error
+		tlsConfig, err = ServerTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, address := range bind {
+		// addresses starting with - are optional, they are skipped when they are not available
+		optional := strings.HasPrefix(address, "-")
+		address = strings.TrimPrefix(address, "-")
+		for _, listenPort := range []int{port, tlsPort} {
+			if listenPort == 0 {
+				continue
+			}
+			listener, err := listenTCP(address, listenPort)
+			if err != nil && optional && isAddressUnavailable(err) {
+				fmt.Printf("Skipping the optional bind address %s: %v\n", address, err)
+				continue
+			}
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			if listenPort == tlsPort {
+				listener = tls.NewListener(listener, tlsConfig)
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+
+	if path := ServerConfig.Get(CONFIG_UNIXSOCKET); path != "" {
+		listener, err := listenUnix(path, ServerConfig.Get(CONFIG_UNIXSOCKETPERM))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	switch len(listeners) {
+	case 0:
+		return nil, errors.New("configured to not listen anywhere, set port, tls-port or unixsocket")
+	case 1:
+		return listeners[0], nil
+	default:
+		return newMultiListener(listeners), nil
+	}
+}
+
+// listenTCP listens on a bind address. * and ::* stand for every IPv4 and every IPv6 address.
+// Literal addresses only listen on their own IP version, so * and ::* can be bound side by side.
+func listenTCP(address string, port int) (net.Listener, error) {
+	network := "tcp"
+	switch address {
+	case "*":
+		network, address = "tcp4", "0.0.0.0"
+	case "::*":
+		network, address = "tcp6", "::"
+	default:
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			network = "tcp4"
+		} else if ip != nil {
+			network = "tcp6"
+		}
+	}
+	return net.Listen(network, net.JoinHostPort(address, strconv.Itoa(port)))
+}
+
+// isAddressUnavailable reports whether listening failed because the address or its protocol is not
+// available on this host, such as an IPv6 address on a host without IPv6
+func isAddressUnavailable(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT) || errors.Is(err, syscall.EPROTONOSUPPORT)
+}
+
+// listenUnix listens on a unix socket, replacing a socket left behind by a previous run. perm holds
+// the octal permissions of the socket file, or 0 to keep the default ones.
+func listenUnix(path string, perm string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unixsocketperm '%s'", perm)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener merges several listeners into one, so servers accept every connection in a single
+// loop and stop listening with a single Close
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{listeners: listeners, accepted: make(chan acceptResult), closed: make(chan struct{})}
+	for _, listener := range listeners {
+		go m.acceptFrom(listener)
+	}
+	return m
+}
+
+func (m *multiListener) acceptFrom(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case m.accepted <- acceptResult{conn, err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-m.accepted:
+		return result.conn, result.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, listener := range m.listeners {
+			if closeErr := listener.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
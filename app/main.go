@@ -1,25 +1,58 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	port := flag.Int("port", DEFAULT_PORT, "port number on which the server will run")
-	replicaOf := flag.String("replicaof", DEFAULT_MASTER, "address of the master server from which to create the replica")
-	rdbFileDir := flag.String("dir", RDB_DEFAULT_DIR, "directory where the RDB file is located")
-	rdbFileName := flag.String("dbfilename", RDB_DEFAULT_FILENAME, "name of the RDB file")
+	err := LoadServerConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println("Failed to load configuration: ", err)
+		os.Exit(1)
+	}
 
-	flag.Parse()
+	err = LoadACL()
+	if err != nil {
+		fmt.Println("Failed to load the ACL: ", err)
+		os.Exit(1)
+	}
 
-	server, err := CreateRedisServer(*port, *replicaOf, *rdbFileDir, *rdbFileName)
+	err = InitDatabases()
+	if err != nil {
+		fmt.Println("Failed to create the databases: ", err)
+		os.Exit(1)
+	}
+
+	if ServerConfig.Get(CONFIG_EXECUTOR) == EXECUTOR_SINGLE {
+		CommandExecutor = NewExecutor()
+	}
+
+	server, err := CreateRedisServer()
 	if err != nil {
 		fmt.Println("Failed to create server: ", err)
 		os.Exit(1)
 	}
 
+	if ServerConfig.Get(CONFIG_APPENDONLY) == CONFIG_YES {
+		err = LoadAppendOnlyFile(server)
+		if err != nil {
+			fmt.Println("Failed to load the append only file: ", err)
+			os.Exit(1)
+		}
+	} else {
+		err = LoadRDBFile()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Failed to load the RDB file: ", err)
+			os.Exit(1)
+		}
+	}
+
+	go handleSignals(server)
+
 	err = server.Start()
 	if err != nil {
 		fmt.Println(err)
@@ -27,3 +60,17 @@ func main() {
 	}
 
 }
+
+// handleSignals shuts the server down on SIGINT and SIGTERM
+func handleSignals(server RedisServer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range signals {
+		fmt.Printf("Received %s, scheduling shutdown...\n", sig)
+		err := server.Shutdown(ShutdownOptions{})
+		if err != nil {
+			fmt.Println("Failed to shut down: ", err)
+		}
+	}
+}
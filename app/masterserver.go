@@ -1,123 +1,194 @@
 package main
 
 import (
-	"encoding/hex"
+	"bytes"
 	"fmt"
 	"net"
-	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Replica struct {
 	conn net.Conn
+	// number of REPLCONF ACK messages received from the replica
+	acks int
 }
 
 type RedisMasterServer struct {
-	Role        string
-	Host        string
-	Port        int
-	Status      ServerStatus
-	listener    net.Listener
+	Role string
+	// addresses the server listens on
+	Bind     []string
+	Port     int
+	Status   ServerStatus
+	listener net.Listener
+	// mu guards the replicas, the command history and the acknowledge item, which are shared by every connection
+	mu          sync.Mutex
 	waitAckFor  *CommandHistoryItem
 	ackChannel  chan bool
-	replicas    []Replica
+	replicas    []*Replica
 	replicaInfo ReplicaInfo
 	history     CommandHistory
-	rdbConfig   map[string]string
+	// propagateMu keeps the SELECT sent before a write and the write itself together, and holds the
+	// writes back while a new replica receives its snapshot
+	propagateMu sync.Mutex
+	// database selected on the replicas by the propagated commands, -1 when the next write must select one
+	replicationDB int
 }
 
-func NewMasterServer(port int, rdbDir, rdbFileName string) RedisMasterServer {
-	server := RedisMasterServer{
+func NewMasterServer(port int) *RedisMasterServer {
+	server := &RedisMasterServer{
 		Role: MASTER,
-		Host: DEFAULT_HOST,
+		Bind: strings.Fields(ServerConfig.Get(CONFIG_BIND)),
 		Port: port,
+		Status: ServerStatus{
+			Lifecycle: NewServerLifecycle(),
+		},
 		replicaInfo: ReplicaInfo{
 			role: MASTER,
 		},
-		rdbConfig: map[string]string{
-			RDB_DIR_ARG:      rdbDir,
-			RDB_FILENAME_ARG: rdbFileName,
-		},
+		replicationDB: -1,
 	}
 
 	return server
 }
 
 func (r *RedisMasterServer) Start() error {
-	port := strconv.Itoa(r.Port)
 	r.replicaInfo.masterReplOffset = 0
 	r.replicaInfo.masterReplid = string(RandByteSliceFromRanges(40, [][]int{{48, 57}, {97, 122}}))
-	listener, err := net.Listen("tcp", r.Host+":"+port)
+	listener, err := r.listen()
 	if err != nil {
 		return err
 	}
+
+	fmt.Println("Master server listening on port", r.Port)
+	go runActiveExpire(r)
+
+	return r.Status.Lifecycle.AcceptConnections(listener, r.listen, func(conn net.Conn) {
+		HandleConnection(conn, r)
+	})
+}
+
+func (r *RedisMasterServer) listen() (net.Listener, error) {
+	listener, err := Listen(r.Bind, r.Port)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
 	r.listener = listener
+	r.mu.Unlock()
+	return listener, nil
+}
 
-	fmt.Println("Master server listening on port", port)
+func (r *RedisMasterServer) Shutdown(options ShutdownOptions) error {
+	stopListening := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.listener.Close()
+	}
+	return ShutdownServer(r, options, stopListening, r.waitForReplicas)
+}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
-			os.Exit(1)
+// waitForReplicas asks every replica for an acknowledgement and waits until all of them answer.
+// Replicas process the replication stream in order, so an answer means every write propagated
+// before the request has been applied.
+func (r *RedisMasterServer) waitForReplicas(timeout time.Duration) {
+	replicas := r.Replicas()
+	if len(replicas) == 0 {
+		return
+	}
+
+	acksBefore := map[*Replica]int{}
+	r.mu.Lock()
+	for _, replica := range replicas {
+		acksBefore[replica] = replica.acks
+	}
+	r.mu.Unlock()
+
+	fmt.Println("Waiting for replicas before shutting down.")
+	r.propagateCommand(ToRespBulkStringArray(REPLCONF, GETACK, GETACK_FROM_REPLICA_ARG))
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		caughtUp := true
+		r.mu.Lock()
+		for _, replica := range replicas {
+			if replica.acks <= acksBefore[replica] {
+				caughtUp = false
+			}
+		}
+		r.mu.Unlock()
+		if caughtUp {
+			return
 		}
-		go HandleConnection(conn, r)
+		time.Sleep(SHUTDOWN_POLL_INTERVAL)
 	}
+	fmt.Println("Timed out waiting for replicas to acknowledge the replication stream")
 }
 
-func (r RedisMasterServer) ReplicaInfo() ReplicaInfo {
+func (r *RedisMasterServer) ReplicaInfo() ReplicaInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.replicaInfo
 }
 
-func (r *RedisMasterServer) RunCommand(cmp CommandComponents, conn net.Conn, t *Transaction) error {
+func (r *RedisMasterServer) ReplicationInfo() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := []string{
+		"role:" + MASTER,
+		fmt.Sprintf("connected_slaves:%d", len(r.replicas)),
+	}
+	for i, replica := range r.replicas {
+		// replicas connected over a unix socket have no ip or port
+		ip, port, err := net.SplitHostPort(replica.conn.RemoteAddr().String())
+		if err != nil {
+			ip, port = "", "0"
+		}
+		info = append(info, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,acks=%d", i, ip, port, replica.acks))
+	}
+	return append(info,
+		"master_replid:"+r.replicaInfo.masterReplid,
+		fmt.Sprintf("master_repl_offset:%d", r.replicaInfo.masterReplOffset),
+	)
+}
+
+func (r *RedisMasterServer) RunCommand(cmp CommandComponents, conn net.Conn, client *Client) error {
 	command, args, commandInput := cmp.Command, cmp.Args, cmp.Input
 	respCommand := RespCommands[command]
+	t := &client.Transaction
 
+	r.mu.Lock()
 	r.history.Append(CommandHistoryItem{&respCommand, args, false, 0})
+	r.mu.Unlock()
 
 	// 1. command executors produce the output to write
-	writeCommandOutput := func() error {
-		result, err := respCommand.Execute(args, r)
-		if err != nil {
-			return err
+	writeResult := func(result string) error {
+		if result == "" {
+			return nil
 		}
-		_, err = conn.Write([]byte(result))
+		_, err := conn.Write([]byte(result))
+		return err
+	}
+	writeCommandOutput := func() error {
+		result, err := respCommand.Execute(args, r, client)
 		if err != nil {
 			return err
 		}
-		return nil
+		return writeResult(result)
 	}
 
 	// 2. handle side effects internally
 	switch command {
 	case PSYNC:
-		err := writeCommandOutput()
-		if err != nil {
-			return err
-		}
-
-		rdbFileBytes, err := hex.DecodeString(RDB_EMPTY_FILE_HEX)
-		if err != nil {
-			return err
-		}
-		rdbFileLength := BULK_STRING + strconv.Itoa(len(rdbFileBytes)) + PROTOCOL_TERMINATOR
-		_, err = conn.Write([]byte(rdbFileLength))
-		if err != nil {
-			return err
-		}
-		_, err = conn.Write(rdbFileBytes)
-		if err != nil {
-			return err
-		}
-
-		r.replicas = append(r.replicas, Replica{conn})
+		return r.fullResync(conn, client, writeCommandOutput)
 	case REPLCONF:
 		concatArgs := strings.Join(args, " ")
 		if matches, _ := regexp.MatchString(ACK+` `+`\d+`, concatArgs); matches {
-			r.waitAckFor.Acks += 1
-			r.ackChannel <- true
+			r.recordReplicaAck(conn)
 			return nil
 		}
 
@@ -137,8 +208,19 @@ func (r *RedisMasterServer) RunCommand(cmp CommandComponents, conn net.Conn, t *
 
 		if t.Conn == nil {
 			result = ToRespError(fmt.Errorf("%s without %s", EXEC, MULTI))
+		} else if t.aborted {
+			t.Reset()
+			result = ToRespErrorWithCode(EXECABORT, ErrExecAbort)
 		} else {
-			result = t.ExecTransaction(r)
+			// like any other write, the transaction is queued before another write can be applied
+			var writes []TransactionWrite
+			ServerPropagation.order.Lock()
+			result, writes = t.ExecTransaction(r, client)
+			if len(writes) > 0 {
+				ServerPropagation.QueueTransaction(r, writes)
+			}
+			ServerPropagation.order.Unlock()
+			ServerPropagation.Send()
 		}
 
 		_, err := conn.Write([]byte(result))
@@ -153,7 +235,7 @@ func (r *RedisMasterServer) RunCommand(cmp CommandComponents, conn net.Conn, t *
 			result = ToRespError(fmt.Errorf("%s without %s", DISCARD, MULTI))
 		} else {
 			t.Reset()
-			result, _ = respCommand.Execute(args, r)
+			result, _ = respCommand.Execute(args, r, client)
 		}
 
 		_, err := conn.Write([]byte(result))
@@ -173,35 +255,179 @@ func (r *RedisMasterServer) RunCommand(cmp CommandComponents, conn net.Conn, t *
 			return nil
 		}
 
-		err := writeCommandOutput()
+		expireCommandKeys(r, client, cmp)
+		if respCommand.Type != WRITE {
+			return writeCommandOutput()
+		}
+
+		// the write is queued before any other write can be applied, so replicas and the append only
+		// file receive the writes of a key in the order they were applied. Writes which failed changed
+		// nothing, so they are not propagated.
+		ServerPropagation.order.Lock()
+		result, err := respCommand.Execute(args, r, client)
+		if err == nil && !strings.HasPrefix(result, ERROR_PREFIX) {
+			Stats.Dirty.Add(1)
+			if input := client.propagatedInput(commandInput); input != "" {
+				ServerPropagation.Queue(r, client.DB(), input)
+			}
+		}
+		ServerPropagation.order.Unlock()
+		ServerPropagation.Send()
 		if err != nil {
 			return err
 		}
+		return writeResult(result)
+	}
+
+	return nil
+}
+
+// fullResync sends the replica at conn the reply to PSYNC, written by writeReply, followed by a
+// snapshot of the dataset, and adds it to the replicas. No write is applied while the snapshot is
+// taken and propagateMu is held until the replica is added, so the replica receives every write
+// which followed the snapshot and none of those it already holds.
+func (r *RedisMasterServer) fullResync(conn net.Conn, client *Client, writeReply func() error) error {
+	dbs := ServerDatabases.All()
+	ServerPropagation.order.Lock()
+	// the writes queued so far are part of the snapshot, so they only reach the replicas already
+	// connected. Expired keys removed until the dataset is locked are still queued, which is harmless:
+	// the replica receives the DEL of a key missing from its snapshot.
+	ServerPropagation.Send()
+	unlock := LockAll(dbs)
+	r.propagateMu.Lock()
+	defer r.propagateMu.Unlock()
 
-		if respCommand.Type == WRITE {
-			r.propagateCommand(commandInput)
+	// streams cannot be encoded in the RDB file yet, they are sent as commands right after it
+	var rdb, commands bytes.Buffer
+	selectedDB := -1
+	err := writeRDB(&rdb, dbs, (*ServerMemory).forEachLocked, func(db int, key string, memItem MemoryItem) {
+		if db != selectedDB {
+			commands.WriteString(ToRespBulkStringArray(SELECT, strconv.Itoa(db)))
+			selectedDB = db
 		}
+		writeSnapshotCommands(&commands, key, memItem)
+	})
+	unlock()
+	ServerPropagation.order.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = writeReply()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(BULK_STRING + strconv.Itoa(rdb.Len()) + PROTOCOL_TERMINATOR))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(rdb.Bytes(), commands.Bytes()...))
+	if err != nil {
+		return err
 	}
 
+	client.SetType(CLIENT_TYPE_REPLICA)
+	r.mu.Lock()
+	r.replicas = append(r.replicas, &Replica{conn: conn})
+	r.mu.Unlock()
+	// the new replica starts on database 0, or on the last one the snapshot selected, so the next
+	// write selects its database again
+	r.replicationDB = -1
 	return nil
 }
 
 func (r *RedisMasterServer) SetAcknowledgeItem(historyItem *CommandHistoryItem, ackChan chan bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.waitAckFor = historyItem
 	r.ackChannel = ackChan
 }
 
-func (r *RedisMasterServer) GetRDBConfig() map[string]string {
-	return r.rdbConfig
+// recordReplicaAck counts an acknowledgement from the replica at conn, notifying WAIT if it is running
+func (r *RedisMasterServer) recordReplicaAck(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, replica := range r.replicas {
+		if replica.conn == conn {
+			replica.acks++
+		}
+	}
+
+	if r.waitAckFor == nil {
+		return
+	}
+	r.waitAckFor.Acks += 1
+	select {
+	case r.ackChannel <- true:
+	default:
+	}
+}
+
+// AcknowledgedCount returns the acknowledgements received for the item WAIT is waiting on
+func (r *RedisMasterServer) AcknowledgedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waitAckFor == nil {
+		return 0
+	}
+	return r.waitAckFor.Acks
+}
+
+// Replicas returns a copy of the currently connected replicas
+func (r *RedisMasterServer) Replicas() []*Replica {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.replicas)
 }
 
 func (r *RedisMasterServer) GetStatus() *ServerStatus {
 	return &r.Status
 }
 
+// propagateWrite propagates a write command run against the database at db, preceded by a SELECT
+// when the replicas have another database selected
+func (r *RedisMasterServer) propagateWrite(db int, rawInput string) {
+	r.propagateMu.Lock()
+	defer r.propagateMu.Unlock()
+	r.propagateCommand(r.selectReplicationDB(db) + rawInput)
+}
+
+// propagateTransaction propagates the writes of a transaction between MULTI and EXEC, in a single
+// part of the replication stream so replicas apply them together
+func (r *RedisMasterServer) propagateTransaction(writes []TransactionWrite) {
+	r.propagateMu.Lock()
+	defer r.propagateMu.Unlock()
+	input := ToRespBulkStringArray(MULTI)
+	for _, write := range writes {
+		input += r.selectReplicationDB(write.db) + write.input
+	}
+	r.propagateCommand(input + ToRespBulkStringArray(EXEC))
+}
+
+// selectReplicationDB returns the SELECT to propagate before a write against the database at db,
+// or nothing when the replicas already have it selected. propagateMu must be held.
+func (r *RedisMasterServer) selectReplicationDB(db int) string {
+	if db == r.replicationDB {
+		return ""
+	}
+	r.replicationDB = db
+	return ToRespBulkStringArray(SELECT, strconv.Itoa(db))
+}
+
+// PropagateExpired queues the removal of an expired key as a DEL, so replicas and the append only
+// file remove it at the same point of the write stream. It runs with the key still locked, the DEL
+// is sent by the next ServerPropagation.Send.
+func (r *RedisMasterServer) PropagateExpired(db int, key string) {
+	ServerPropagation.Queue(r, db, ToRespBulkStringArray(DEL, key))
+}
+
 func (r *RedisMasterServer) propagateCommand(rawInput string /* historyItem *CommandHistoryItem */) []error {
+	r.mu.Lock()
+	r.replicaInfo.masterReplOffset += len(rawInput)
+	r.mu.Unlock()
+
 	errors := []error{}
-	for _, replica := range r.replicas {
+	for _, replica := range r.Replicas() {
 		fmt.Println("Propagating command to: ", replica.conn.RemoteAddr().String())
 		_, err := replica.conn.Write([]byte(rawInput))
 		if err != nil {
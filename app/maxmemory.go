@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"runtime/metrics"
+	"slices"
+)
+
+const OOM = "OOM"
+
+// the only policy supported: nothing is evicted, commands which may grow the dataset are refused instead
+const MAXMEMORY_POLICY_NOEVICTION = "noeviction"
+
+var ErrOutOfMemory = errors.New("command not allowed when used memory > 'maxmemory'.")
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// UsedMemory returns the bytes taken by live and not yet swept heap objects, the same figure as
+// the HeapAlloc reported by INFO, without stopping the world to read it.
+func UsedMemory() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// rejectsForMaxMemory reports whether the command must be refused because it may grow the
+// dataset while the used memory is over maxmemory. Writes from the master are always applied,
+// the master enforces its own limit.
+func rejectsForMaxMemory(client *Client, respCommand RespCommand) bool {
+	maxMemory := ServerConfig.GetInt(CONFIG_MAXMEMORY)
+	if maxMemory <= 0 || !slices.Contains(respCommand.flags, "denyoom") || client.Type() == CLIENT_TYPE_MASTER {
+		return false
+	}
+	return UsedMemory() > uint64(maxMemory)
+}
@@ -3,19 +3,101 @@ package main
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"math/rand"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type ServerMemory map[string]MemoryItem
+// Keyspace sharding. Keys are assigned to shards by the top bits of their hash so that
+// every shard can be locked independently.
+const (
+	MEMORY_SHARD_BITS  = 5
+	MEMORY_SHARD_COUNT = 1 << MEMORY_SHARD_BITS
+)
+
+// SCAN buckets. Keys are grouped by the top bits of their hash, which start with the shard bits, so
+// every shard holds a contiguous range of buckets and a bucket number is a position in the keyspace.
+const (
+	MEMORY_SCAN_BUCKET_BITS  = 16
+	MEMORY_SCAN_BUCKET_COUNT = 1 << MEMORY_SCAN_BUCKET_BITS
+	MEMORY_SHARD_BUCKETS     = MEMORY_SCAN_BUCKET_COUNT / MEMORY_SHARD_COUNT
+)
+
+// ServerMemory is the concurrency-safe keyspace. Every shard holds its own lock, so commands
+// on keys that live in different shards never contend with each other.
+type ServerMemory struct {
+	// id orders the locks taken on two keyspaces at once, such as by MOVE
+	id     int64
+	shards [MEMORY_SHARD_COUNT]*memoryShard
+}
+
+type memoryShard struct {
+	mu    sync.RWMutex
+	items map[string]MemoryItem
+	// expires indexes the keys of items with an expiry, so they can be sampled by the active expire
+	// cycle. Items must be stored and removed through set and remove to keep it up to date.
+	expires map[string]struct{}
+	// buckets indexes the keys by scan bucket, holding only the non-empty buckets, whose numbers
+	// occupied keeps sorted so a scan skips straight to the next one
+	buckets  map[int]map[string]struct{}
+	occupied []int
+}
 
-var Memory ServerMemory = ServerMemory{}
+func newMemoryShard() *memoryShard {
+	return &memoryShard{items: map[string]MemoryItem{}, expires: map[string]struct{}{}, buckets: map[int]map[string]struct{}{}}
+}
+
+func (s *memoryShard) set(key string, item MemoryItem) {
+	if _, exists := s.items[key]; !exists {
+		bucket := scanBucket(key)
+		if s.buckets[bucket] == nil {
+			s.buckets[bucket] = map[string]struct{}{}
+			i, _ := slices.BinarySearch(s.occupied, bucket)
+			s.occupied = slices.Insert(s.occupied, i, bucket)
+		}
+		s.buckets[bucket][key] = struct{}{}
+	}
+	s.items[key] = item
+	if item.expires != 0 {
+		s.expires[key] = struct{}{}
+	} else {
+		delete(s.expires, key)
+	}
+}
+
+func (s *memoryShard) remove(key string) bool {
+	_, exists := s.items[key]
+	if !exists {
+		return false
+	}
+	delete(s.items, key)
+	delete(s.expires, key)
+	bucket := scanBucket(key)
+	delete(s.buckets[bucket], key)
+	if len(s.buckets[bucket]) == 0 {
+		delete(s.buckets, bucket)
+		i, _ := slices.BinarySearch(s.occupied, bucket)
+		s.occupied = slices.Delete(s.occupied, i, i+1)
+	}
+	return true
+}
+
+var lastMemoryId atomic.Int64
 
 // Memory errors
 var (
 	ErrExpiredKey = errors.New("expired key")
+	ErrWrongType  = errors.New("Operation against a key holding the wrong kind of value")
 )
 
+// error code of commands run against a key holding another type of value
+const WRONGTYPE = "WRONGTYPE"
+
 // memory item types
 const (
 	INT    = "int"
@@ -29,27 +111,361 @@ const (
 	XRANGE_PLUS  = "+"
 )
 
-func (m *ServerMemory) AddStreamItem(key string, s Stream) error {
-	memItem, exists := Memory[key]
+func NewServerMemory() *ServerMemory {
+	m := &ServerMemory{id: lastMemoryId.Add(1)}
+	for i := range m.shards {
+		m.shards[i] = newMemoryShard()
+	}
+	return m
+}
 
-	if !exists {
-		Memory[key] = MemoryItem{&StreamValue{s}, 0}
-		return nil
+// KeyHash returns the 64-bit FNV-1a hash of the key
+func KeyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func shardIndex(key string) int {
+	return int(KeyHash(key) >> (64 - MEMORY_SHARD_BITS))
+}
+
+func scanBucket(key string) int {
+	return int(KeyHash(key) >> (64 - MEMORY_SCAN_BUCKET_BITS))
+}
+
+func (m *ServerMemory) shardFor(key string) *memoryShard {
+	return m.shards[shardIndex(key)]
+}
+
+// Get returns the item stored at key, without checking its expiry
+func (m *ServerMemory) Get(key string) (MemoryItem, bool) {
+	shard := m.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item, exists := shard.items[key]
+	return item, exists
+}
+
+func (m *ServerMemory) Set(key string, item MemoryItem) {
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.set(key, item)
+}
+
+// Delete removes the key, returning whether it existed
+func (m *ServerMemory) Delete(key string) bool {
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.remove(key)
+}
+
+// DeleteIfExpired removes the key if it has expired, reporting whether it did. removed is called
+// before the shard is unlocked, so the removal can be queued for propagation ahead of any later write
+// of the key.
+func (m *ServerMemory) DeleteIfExpired(key string, removed func()) bool {
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	item, exists := shard.items[key]
+	if !exists || !item.Expired() {
+		return false
 	}
+	shard.remove(key)
+	removed()
+	return true
+}
 
-	value, valueType := memItem.GetValueDirectly()
-	if valueType != STREAM {
-		return fmt.Errorf("cannot insert stream s to non-stream key `%s`", key)
+// ExpireSample checks up to count keys with an expiry, visiting shards from a random one, and removes
+// those which expired, calling removed for each of them before their shard is unlocked. It returns
+// the number of keys checked and of keys removed.
+func (m *ServerMemory) ExpireSample(count int, removed func(key string)) (int, int) {
+	sampled, expired := 0, 0
+	now := time.Now().UnixMilli()
+	start := rand.Intn(MEMORY_SHARD_COUNT)
+	for i := 0; i < MEMORY_SHARD_COUNT && sampled < count; i++ {
+		shard := m.shards[(start+i)%MEMORY_SHARD_COUNT]
+		shard.mu.Lock()
+		for key := range shard.expires {
+			if sampled == count {
+				break
+			}
+			sampled++
+			if shard.items[key].expires < now {
+				shard.remove(key)
+				removed(key)
+				expired++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return sampled, expired
+}
+
+// Update atomically replaces the item at key with the one returned by fn. fn receives the current
+// item and whether it exists, and must return the new item and whether it should be stored.
+// No other access to the key can happen while fn runs, so fn must not access Memory itself.
+func (m *ServerMemory) Update(key string, fn func(item MemoryItem, exists bool) (MemoryItem, bool)) {
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	item, exists := shard.items[key]
+	if newItem, store := fn(item, exists); store {
+		shard.set(key, newItem)
 	}
-	stream := *(value.(*StreamValue))
-	stream = append(stream, s)
-	Memory[key] = MemoryItem{&stream, 0}
-	return nil
+}
+
+// Atomic locks every shard holding one of keys, in a fixed order to avoid deadlocks, and runs fn
+// with unsynchronized access to those keys. Use it for operations which read or write several keys.
+func (m *ServerMemory) Atomic(keys []string, fn func(locked *LockedKeys)) {
+	indexes := []int{}
+	for _, key := range keys {
+		index := shardIndex(key)
+		if !slices.Contains(indexes, index) {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+
+	for _, index := range indexes {
+		m.shards[index].mu.Lock()
+	}
+	defer func() {
+		for _, index := range indexes {
+			m.shards[index].mu.Unlock()
+		}
+	}()
+
+	fn(&LockedKeys{m, indexes})
+}
+
+// Len returns the number of keys in memory, expired or not
+func (m *ServerMemory) Len() int {
+	total := 0
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		total += len(shard.items)
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// ExpiresLen returns the number of keys in memory with an expiry set
+func (m *ServerMemory) ExpiresLen() int {
+	total := 0
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		total += len(shard.expires)
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// ForEach calls fn with every key and item in memory, expired or not. Each shard is read under its
+// own lock, so fn must not access Memory itself and does not see a point-in-time snapshot of the
+// whole keyspace.
+func (m *ServerMemory) ForEach(fn func(key string, item MemoryItem)) {
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		for key, item := range shard.items {
+			fn(key, item)
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// Scan visits the non-empty buckets from cursor on, returning the keys for which keep reports true,
+// until it has visited count keys. Empty buckets are skipped without being visited, so a scan takes
+// as many calls as the keys require whatever the size of the bucket space. Buckets are visited whole
+// and keys never change buckets, so a key present during the whole scan is returned exactly once. It
+// returns the bucket to resume from, or 0 once every bucket was visited.
+func (m *ServerMemory) Scan(cursor uint64, count int, keep func(key string, item MemoryItem) bool) (uint64, []string) {
+	keys := []string{}
+	visited := 0
+	for cursor < MEMORY_SCAN_BUCKET_COUNT && visited < count {
+		index := cursor / MEMORY_SHARD_BUCKETS
+		shard := m.shards[index]
+		shard.mu.RLock()
+		i, _ := slices.BinarySearch(shard.occupied, int(cursor))
+		for ; i < len(shard.occupied) && visited < count; i++ {
+			bucket := shard.buckets[shard.occupied[i]]
+			for key := range bucket {
+				if keep(key, shard.items[key]) {
+					keys = append(keys, key)
+				}
+			}
+			visited += len(bucket)
+		}
+		if i < len(shard.occupied) {
+			cursor = uint64(shard.occupied[i])
+		} else {
+			cursor = (index + 1) * MEMORY_SHARD_BUCKETS
+		}
+		shard.mu.RUnlock()
+	}
+	if cursor >= MEMORY_SCAN_BUCKET_COUNT {
+		cursor = 0
+	}
+	return cursor, keys
+}
+
+// Flush removes every key, returning the number of keys removed
+func (m *ServerMemory) Flush() int {
+	removed := 0
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		removed += len(shard.items)
+		shard.items, shard.expires, shard.buckets, shard.occupied = map[string]MemoryItem{}, map[string]struct{}{}, map[int]map[string]struct{}{}, nil
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// lockAcross locks the shard holding key in m and the one holding otherKey in other, in a fixed order
+// to avoid deadlocks, and returns both shards along with the function releasing them
+func (m *ServerMemory) lockAcross(key string, other *ServerMemory, otherKey string) (*memoryShard, *memoryShard, func()) {
+	index, otherIndex := shardIndex(key), shardIndex(otherKey)
+	shard, otherShard := m.shards[index], other.shards[otherIndex]
+	if shard == otherShard {
+		shard.mu.Lock()
+		return shard, shard, shard.mu.Unlock
+	}
+
+	first, second := shard, otherShard
+	if other.id < m.id || (other.id == m.id && otherIndex < index) {
+		first, second = otherShard, shard
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	return shard, otherShard, func() {
+		second.mu.Unlock()
+		first.mu.Unlock()
+	}
+}
+
+// LockAll locks every shard of the memories, in the order of lockAcross, and returns the function
+// releasing them. It gives a point-in-time view of several keyspaces, read through forEachLocked.
+func LockAll(memories []*ServerMemory) func() {
+	ordered := slices.Clone(memories)
+	slices.SortFunc(ordered, func(a, b *ServerMemory) int { return int(a.id - b.id) })
+	for _, m := range ordered {
+		for _, shard := range m.shards {
+			shard.mu.Lock()
+		}
+	}
+	return func() {
+		for _, m := range ordered {
+			for _, shard := range m.shards {
+				shard.mu.Unlock()
+			}
+		}
+	}
+}
+
+// forEachLocked calls fn with every key and item of a memory locked by LockAll
+func (m *ServerMemory) forEachLocked(fn func(key string, item MemoryItem)) {
+	for _, shard := range m.shards {
+		for key, item := range shard.items {
+			fn(key, item)
+		}
+	}
+}
+
+// Move moves key to dst, keeping its expiry, unless key does not exist or dst already holds it.
+// Keys for which expired reports true count as missing. It reports whether the key was moved.
+func (m *ServerMemory) Move(key string, dst *ServerMemory, expired func(MemoryItem) bool) bool {
+	if m == dst {
+		return false
+	}
+	shard, dstShard, unlock := m.lockAcross(key, dst, key)
+	defer unlock()
+
+	item, exists := shard.items[key]
+	if !exists || expired(item) {
+		return false
+	}
+	if dstItem, exists := dstShard.items[key]; exists && !expired(dstItem) {
+		return false
+	}
+	shard.remove(key)
+	dstShard.set(key, item)
+	return true
+}
+
+// Copy copies the value at key to dstKey in dst, keeping its expiry. An existing dstKey is only
+// replaced when replace is set. Keys for which expired reports true count as missing. It reports
+// whether the key was copied.
+func (m *ServerMemory) Copy(key string, dst *ServerMemory, dstKey string, replace bool, expired func(MemoryItem) bool) bool {
+	shard, dstShard, unlock := m.lockAcross(key, dst, dstKey)
+	defer unlock()
+
+	item, exists := shard.items[key]
+	if !exists || expired(item) {
+		return false
+	}
+	if dstItem, exists := dstShard.items[dstKey]; exists && !expired(dstItem) && !replace {
+		return false
+	}
+	dstShard.set(dstKey, MemoryItem{item.value.clone(), item.expires})
+	return true
+}
+
+// RandomKey returns a random key which has not expired, or false when there is none. Shards are
+// visited from a random one, and Go randomizes where each map iteration starts.
+func (m *ServerMemory) RandomKey() (string, bool) {
+	start := rand.Intn(MEMORY_SHARD_COUNT)
+	for i := range m.shards {
+		shard := m.shards[(start+i)%MEMORY_SHARD_COUNT]
+		shard.mu.RLock()
+		for key, item := range shard.items {
+			if !item.Expired() {
+				shard.mu.RUnlock()
+				return key, true
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return "", false
+}
+
+// AddStreamItem generates the id for a new stream entry from idArg, appends the entry to the
+// stream at key, creating it if it does not exist or expired reports true for it, and returns the
+// new id.
+func (m *ServerMemory) AddStreamItem(key, idArg string, entries []string, expired func(MemoryItem) bool) (string, error) {
+	var newId string
+	var err error
+
+	m.Update(key, func(item MemoryItem, exists bool) (MemoryItem, bool) {
+		stream := StreamValue{}
+		// the stream keeps the expiry of the key it is added to
+		expires := int64(0)
+		if exists && !expired(item) {
+			expires = item.expires
+			value, valueType := item.GetValueDirectly()
+			if valueType != STREAM {
+				err = fmt.Errorf("cannot insert stream s to non-stream key `%s`", key)
+				return item, false
+			}
+			stream = *(value.(*StreamValue))
+		}
+
+		newId, err = GenerateStreamId(stream, idArg)
+		if err != nil {
+			return item, false
+		}
+
+		stream = append(stream, NewStreamItem(newId, entries))
+		return MemoryItem{&stream, expires}, true
+	})
+
+	return newId, err
 }
 
 func (m *ServerMemory) LookupStream(key string) (StreamValue, error) {
-	memItem, ok := (*m)[key]
-	if !ok {
+	memItem, ok := m.Get(key)
+	if !ok || memItem.Expired() {
 		return nil, fmt.Errorf("stream with key %s does not exist", key)
 	}
 	value, valueType := memItem.GetValueDirectly()
@@ -59,6 +475,34 @@ func (m *ServerMemory) LookupStream(key string) (StreamValue, error) {
 	return *(value.(*StreamValue)), nil
 }
 
+// LockedKeys gives access to the keys locked by ServerMemory.Atomic. Accessing a key whose shard
+// is not locked panics.
+type LockedKeys struct {
+	memory  *ServerMemory
+	indexes []int
+}
+
+func (l *LockedKeys) shardFor(key string) *memoryShard {
+	index := shardIndex(key)
+	if !slices.Contains(l.indexes, index) {
+		panic("key " + key + " was not locked")
+	}
+	return l.memory.shards[index]
+}
+
+func (l *LockedKeys) Get(key string) (MemoryItem, bool) {
+	item, exists := l.shardFor(key).items[key]
+	return item, exists
+}
+
+func (l *LockedKeys) Set(key string, item MemoryItem) {
+	l.shardFor(key).set(key, item)
+}
+
+func (l *LockedKeys) Delete(key string) bool {
+	return l.shardFor(key).remove(key)
+}
+
 type MemoryItem struct {
 	value   MemoryItemValue
 	expires int64
@@ -66,6 +510,8 @@ type MemoryItem struct {
 
 type MemoryItemValue interface {
 	getValue() (interface{}, string)
+	// clone returns a copy which shares nothing that either value may change
+	clone() MemoryItemValue
 }
 
 func NewMemoryItem(value MemoryItemValue, expires int64) MemoryItem {
@@ -75,8 +521,13 @@ func NewMemoryItem(value MemoryItemValue, expires int64) MemoryItem {
 	}
 }
 
+// Expired reports whether the item has an expiry which has passed
+func (c *MemoryItem) Expired() bool {
+	return c.expires != 0 && time.Now().UnixMilli() > c.expires
+}
+
 func (c *MemoryItem) GetValue() (interface{}, error) {
-	if c.expires != 0 && time.Now().UnixMilli() > c.expires {
+	if c.Expired() {
 		return "", ErrExpiredKey
 	}
 
@@ -87,6 +538,15 @@ func (c *MemoryItem) GetValueDirectly() (interface{}, string) {
 	return c.value.getValue()
 }
 
+// TypeName returns the type of the value as TYPE replies it. Integers are strings to clients.
+func (c *MemoryItem) TypeName() string {
+	_, valueType := c.value.getValue()
+	if valueType == INT {
+		return STRING
+	}
+	return valueType
+}
+
 // ToRespString transforms the value into the required response RESP string.
 // It receives the same list of arguments as the command does on each RespCommand Execute call.
 func (m *MemoryItem) ToRespString() (string, error) {
@@ -108,18 +568,38 @@ func (m *MemoryItem) ToRespString() (string, error) {
 	}
 }
 
+// NewStringValue returns the value stored for s, which is kept as an integer when s is one
+func NewStringValue(s string) MemoryItemValue {
+	if numericValue, err := strconv.Atoi(s); err == nil {
+		value := IntegerValue(numericValue)
+		return &value
+	}
+	value := StringValue(s)
+	return &value
+}
+
 type IntegerValue int
 
 func (s *IntegerValue) getValue() (interface{}, string) {
 	return s, INT
 }
 
+func (s *IntegerValue) clone() MemoryItemValue {
+	value := *s
+	return &value
+}
+
 type StringValue string
 
 func (s *StringValue) getValue() (interface{}, string) {
 	return s, STRING
 }
 
+func (s *StringValue) clone() MemoryItemValue {
+	value := *s
+	return &value
+}
+
 type Stream struct {
 	id      string
 	values  map[string]interface{}
@@ -147,6 +627,15 @@ func (s *StreamValue) getValue() (interface{}, string) {
 	return s, STREAM
 }
 
+// clone copies the entries too, since XADD appends to the backing array of the stream
+func (s *StreamValue) clone() MemoryItemValue {
+	value := make(StreamValue, len(*s))
+	for i, entry := range *s {
+		value[i] = Stream{id: entry.id, values: maps.Clone(entry.values), created: entry.created}
+	}
+	return &value
+}
+
 func (s *StreamValue) LookupItem(id string) (Stream, int, error) {
 	for i, stream := range *s {
 		itemId := stream.id
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	stressGoroutines = 32
+	stressIterations = 200
+)
+
+// runConcurrently runs fn on stressGoroutines goroutines and waits for all of them
+func runConcurrently(fn func(worker int)) {
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < stressGoroutines; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(worker)
+		}()
+	}
+	wg.Wait()
+}
+
+func increment(item MemoryItem, exists bool) (MemoryItem, bool) {
+	value := IntegerValue(1)
+	if exists {
+		value = *(item.value.(*IntegerValue)) + 1
+	}
+	return MemoryItem{&value, 0}, true
+}
+
+func TestConcurrentUpdateLosesNoIncrement(t *testing.T) {
+	memory := NewServerMemory()
+	runConcurrently(func(worker int) {
+		for i := 0; i < stressIterations; i++ {
+			memory.Update("counter", increment)
+			memory.Get("counter")
+		}
+	})
+
+	item, exists := memory.Get("counter")
+	if !exists {
+		t.Fatal("counter does not exist")
+	}
+	if got := int(*(item.value.(*IntegerValue))); got != stressGoroutines*stressIterations {
+		t.Fatalf("counter is %d, want %d", got, stressGoroutines*stressIterations)
+	}
+}
+
+func TestConcurrentAtomicKeepsMultiKeyInvariant(t *testing.T) {
+	memory := NewServerMemory()
+	keys := []string{"a", "b", "c", "d"}
+	for _, key := range keys {
+		memory.Set(key, NewMemoryItem(NewStringValue("100"), 0))
+	}
+	valueOf := func(locked *LockedKeys, key string) int {
+		item, _ := locked.Get(key)
+		return int(*(item.value.(*IntegerValue)))
+	}
+
+	runConcurrently(func(worker int) {
+		for i := 0; i < stressIterations; i++ {
+			from, to := keys[(worker+i)%len(keys)], keys[(worker+i+1)%len(keys)]
+			// keys are given in any order, Atomic must lock them without deadlocking
+			memory.Atomic([]string{to, from}, func(locked *LockedKeys) {
+				locked.Set(from, NewMemoryItem(NewStringValue(strconv.Itoa(valueOf(locked, from)-1)), 0))
+				locked.Set(to, NewMemoryItem(NewStringValue(strconv.Itoa(valueOf(locked, to)+1)), 0))
+			})
+			memory.Atomic(keys, func(locked *LockedKeys) {
+				total := 0
+				for _, key := range keys {
+					total += valueOf(locked, key)
+				}
+				if total != 100*len(keys) {
+					t.Errorf("keys hold %d in total, want %d", total, 100*len(keys))
+				}
+			})
+		}
+	})
+}
+
+func TestConcurrentAddStreamItemKeepsEveryEntry(t *testing.T) {
+	memory := NewServerMemory()
+	expired := func(item MemoryItem) bool { return item.Expired() }
+	runConcurrently(func(worker int) {
+		for i := 0; i < stressIterations; i++ {
+			_, err := memory.AddStreamItem("stream", "*", []string{"worker", strconv.Itoa(worker)}, expired)
+			if err != nil {
+				t.Errorf("XADD failed: %v", err)
+				return
+			}
+			memory.LookupStream("stream")
+		}
+	})
+
+	stream, err := memory.LookupStream("stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream) != stressGoroutines*stressIterations {
+		t.Fatalf("stream has %d entries, want %d", len(stream), stressGoroutines*stressIterations)
+	}
+	for i := 1; i < len(stream); i++ {
+		if !streamIdLess(stream[i-1].id, stream[i].id) {
+			t.Fatalf("entry %s is not after %s", stream[i].id, stream[i-1].id)
+		}
+	}
+}
+
+// streamIdLess reports whether the stream id a comes before b
+func streamIdLess(a, b string) bool {
+	aParts, _, _ := splitStreamId(a)
+	bParts, _, _ := splitStreamId(b)
+	return aParts[0] < bParts[0] || (aParts[0] == bParts[0] && aParts[1] < bParts[1])
+}
+
+func TestScanReturnsKeysPresentThroughoutOnce(t *testing.T) {
+	memory := NewServerMemory()
+	stable := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		key := "stable:" + strconv.Itoa(i)
+		memory.Set(key, NewMemoryItem(NewStringValue("1"), 0))
+		stable[key] = 0
+	}
+
+	// keys come and go while the scan runs
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := "churn:" + strconv.Itoa(i%1000)
+			if i%2000 < 1000 {
+				memory.Set(key, NewMemoryItem(NewStringValue("1"), 0))
+			} else {
+				memory.Delete(key)
+			}
+		}
+	}()
+
+	const count = 10
+	cursor, calls := uint64(0), 0
+	for {
+		next, keys := memory.Scan(cursor, count, func(key string, item MemoryItem) bool { return true })
+		calls++
+		// a call stops after the bucket which reached count, and buckets hold a few keys at most
+		if len(keys) > 2*count {
+			t.Fatalf("scan from %d returned %d keys for COUNT %d", cursor, len(keys), count)
+		}
+		for _, key := range keys {
+			if _, exists := stable[key]; exists {
+				stable[key]++
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	for key, returned := range stable {
+		if returned != 1 {
+			t.Fatalf("%s was returned %d times", key, returned)
+		}
+	}
+	if calls < 5000/(2*count) {
+		t.Fatalf("the scan took %d calls, COUNT is not bounding them", calls)
+	}
+}
+
+func TestScanSkipsEmptyBuckets(t *testing.T) {
+	memory := NewServerMemory()
+	for i := 0; i < 3; i++ {
+		memory.Set("key:"+strconv.Itoa(i), NewMemoryItem(NewStringValue("1"), 0))
+	}
+
+	// a few keys spread over the whole bucket space are returned by a single call
+	cursor, keys := memory.Scan(0, SCAN_DEFAULT_COUNT, func(key string, item MemoryItem) bool { return true })
+	if cursor != 0 || len(keys) != 3 {
+		t.Fatalf("scan returned cursor %d and %d keys, want cursor 0 and 3 keys", cursor, len(keys))
+	}
+}
@@ -29,7 +29,8 @@ func splitStreamId(id string) ([2]int, [2]string, error) {
 	return [2]int{ms, seq}, [2]string{splitId[0], splitId[1]}, nil
 }
 
-func GenerateStreamId(memoryKey, id string) (string, error) {
+// GenerateStreamId returns the id for a new entry of stream, built from the id argument given to XADD
+func GenerateStreamId(stream StreamValue, id string) (string, error) {
 	tSplitId, rSplitId, err := splitStreamId(id)
 	if err != nil {
 		return "", err
@@ -40,9 +41,8 @@ func GenerateStreamId(memoryKey, id string) (string, error) {
 		return "", errors.New("the ID specified in XADD must be greater than 0-0")
 	}
 
-	memItem, ok := Memory[memoryKey]
 	rawMs, _ := rSplitId[0], rSplitId[1]
-	if !ok {
+	if len(stream) == 0 {
 		if seq != -1 {
 			return strconv.Itoa(ms) + "-" + strconv.Itoa(seq), nil
 		}
@@ -52,16 +52,15 @@ func GenerateStreamId(memoryKey, id string) (string, error) {
 		return rawMs + "-" + "0", nil
 	}
 
-	value, valueType := memItem.GetValueDirectly()
-	if valueType != "stream" {
-		return "", errors.New("cannot validate against a non-stream key " + memoryKey)
-	}
-
-	streamPtr := value.(*StreamValue)
-	stream := *streamPtr
 	tLastSplitId, _, _ := splitStreamId(stream[len(stream)-1].id)
 	lastMs, lastSeq := tLastSplitId[0], tLastSplitId[1]
 
+	// entries added within the same millisecond as the last one, or while the clock is behind it,
+	// follow it with the next sequence number
+	if id == "*" && ms <= lastMs {
+		return strconv.Itoa(lastMs) + "-" + strconv.Itoa(lastSeq+1), nil
+	}
+
 	if seq != -1 {
 		if ms > lastMs {
 			return strconv.Itoa(ms) + "-" + "0", nil
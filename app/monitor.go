@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// number of lines queued for a monitor before it is considered too slow and disconnected
+const MONITOR_QUEUE_SIZE = 1024
+
+// MonitorRegistry holds the connections in monitor mode. Every command processed by the server is
+// streamed to them.
+type MonitorRegistry struct {
+	mu       sync.Mutex
+	monitors map[net.Conn]chan string
+	// count mirrors len(monitors), so feeding commands costs a single atomic load when no one is monitoring
+	count atomic.Int32
+}
+
+var ServerMonitors = &MonitorRegistry{monitors: map[net.Conn]chan string{}}
+
+// Add puts conn in monitor mode. Lines are written by a goroutine of its own, so a slow monitor
+// never blocks the commands being monitored.
+func (m *MonitorRegistry) Add(conn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.monitors[conn]; exists {
+		return
+	}
+	lines := make(chan string, MONITOR_QUEUE_SIZE)
+	m.monitors[conn] = lines
+	m.count.Add(1)
+
+	go func() {
+		for line := range lines {
+			_, err := conn.Write([]byte(line))
+			if err != nil {
+				m.Remove(conn)
+				return
+			}
+		}
+	}()
+}
+
+// Remove takes conn out of monitor mode, if it was in it
+func (m *MonitorRegistry) Remove(conn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lines, exists := m.monitors[conn]
+	if !exists {
+		return
+	}
+	delete(m.monitors, conn)
+	m.count.Add(-1)
+	close(lines)
+}
+
+// Feed streams a command to every monitor. Monitors which fall too far behind are disconnected.
+func (m *MonitorRegistry) Feed(db int, clientAddr string, command string, args []string) {
+	if m.count.Load() == 0 {
+		return
+	}
+
+	line := FormatMonitorLine(time.Now(), db, clientAddr, command, args)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn, lines := range m.monitors {
+		select {
+		case lines <- line:
+		default:
+			fmt.Println("Disconnecting a monitor which cannot keep up: ", conn.RemoteAddr())
+			delete(m.monitors, conn)
+			m.count.Add(-1)
+			close(lines)
+			conn.Close()
+		}
+	}
+}
+
+// FormatMonitorLine formats a command the way MONITOR shows it: +<timestamp> [db addr] "cmd" "arg"...
+func FormatMonitorLine(now time.Time, db int, clientAddr string, command string, args []string) string {
+	line := strings.Builder{}
+	fmt.Fprintf(&line, "+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, db, clientAddr)
+	for _, arg := range append([]string{strings.ToLower(command)}, args...) {
+		line.WriteByte(' ')
+		line.WriteString(QuoteRepr(arg))
+	}
+	line.WriteString(PROTOCOL_TERMINATOR)
+	return line.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// PropagationQueue orders the writes sent to the replicas and fed to the append only file. A write
+// takes its place in the queue before any other write of its keys can be applied, which costs no I/O,
+// and the queue is sent once the keyspace is unlocked, so a slow replica or disk never holds up other
+// commands while the writes still reach them in the order they were applied.
+type PropagationQueue struct {
+	// order is held by commands from before they apply a write until it is queued, so writes are
+	// queued in the order they were applied. It is taken before any lock of the keyspace. The DEL of
+	// expired keys are queued under the lock of their shard instead, which is enough to keep them
+	// ahead of any later write of the key.
+	order  sync.Mutex
+	mu     sync.Mutex
+	queued []propagatedWrites
+	// sending is held while queued writes are sent, so they go out in the order they were queued
+	sending sync.Mutex
+}
+
+// propagatedWrites are writes applied together, propagated by the master which applied them
+type propagatedWrites struct {
+	server *RedisMasterServer
+	writes []TransactionWrite
+	// transaction propagates the writes between MULTI and EXEC
+	transaction bool
+}
+
+var ServerPropagation = &PropagationQueue{}
+
+// Queue queues a write applied by server against the database at db, in its raw RESP form
+func (q *PropagationQueue) Queue(server *RedisMasterServer, db int, rawInput string) {
+	q.push(propagatedWrites{server, []TransactionWrite{{db, rawInput}}, false})
+}
+
+// QueueTransaction queues the writes of a transaction between MULTI and EXEC
+func (q *PropagationQueue) QueueTransaction(server *RedisMasterServer, writes []TransactionWrite) {
+	q.push(propagatedWrites{server, writes, true})
+}
+
+func (q *PropagationQueue) push(writes propagatedWrites) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued = append(q.queued, writes)
+}
+
+// Send sends the queued writes to the replicas and to the append only file. It must be called with
+// no lock of the keyspace held, by every caller of Queue once its keys are unlocked, so no write
+// stays queued for long.
+func (q *PropagationQueue) Send() {
+	q.sending.Lock()
+	defer q.sending.Unlock()
+	sendPropagatedWrites(q.take())
+}
+
+// take removes and returns the queued writes
+func (q *PropagationQueue) take() []propagatedWrites {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := q.queued
+	q.queued = nil
+	return queued
+}
+
+// sendPropagatedWrites sends writes taken from the queue. sending must be held.
+func sendPropagatedWrites(queued []propagatedWrites) {
+	for _, propagated := range queued {
+		if propagated.transaction {
+			propagated.server.propagateTransaction(propagated.writes)
+			FeedAppendOnlyTransaction(propagated.writes)
+			continue
+		}
+		for _, write := range propagated.writes {
+			propagated.server.propagateWrite(write.db, write.input)
+			FeedAppendOnly(write.db, write.input)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+const DENIED = "DENIED"
+
+var ErrProtectedMode = errors.New("Redis is running in protected mode because protected mode is enabled and no password is set for the default user. " +
+	"In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers to Redis you may adopt one of the following solutions: " +
+	"1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface by connecting to Redis from the same host the server is running, " +
+	"however MAKE SURE Redis is not publicly accessible from internet if you do so. Use CONFIG REWRITE to make this change permanent. " +
+	"2) Alternatively you can just disable the protected mode by editing the Redis configuration file, and setting the protected mode option to 'no', and then restarting the server. " +
+	"3) If you started the server manually just for testing, restart it with the '--protected-mode no' option. " +
+	"4) Set up an authentication password for the default user. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside.")
+
+// deniedByProtectedMode reports whether the client must be refused because protected mode is on, the
+// default user has no password and the client does not connect from the loopback interface or the
+// unix socket
+func deniedByProtectedMode(client *Client) bool {
+	if ServerConfig.Get(CONFIG_PROTECTED_MODE) != CONFIG_YES || !ServerACL.User(DEFAULT_USER).nopass {
+		return false
+	}
+	if client.isUnixSocket() {
+		return false
+	}
+	host, _, err := net.SplitHostPort(client.Conn.RemoteAddr().String())
+	if err != nil {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
@@ -13,6 +13,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type RDBValue interface {
@@ -23,6 +24,8 @@ type RDBTableEntry struct {
 	key    string
 	value  string
 	expiry int64
+	// index of the database the key belongs to
+	db int
 }
 
 func LoadFile(path string) error {
@@ -248,171 +251,201 @@ func RDBHexStringToByte(hexString string) (byte, error) {
 	return bytes[0], nil
 }
 
-func GetRDBFilePath(r RedisServer) string {
-	rdbConfig := r.GetRDBConfig()
-	filePath := filepath.Join(rdbConfig[RDB_DIR_ARG], rdbConfig[RDB_FILENAME_ARG])
+func GetRDBFilePath() string {
+	filePath := filepath.Join(ServerConfig.Get(CONFIG_DIR), ServerConfig.Get(CONFIG_DBFILENAME))
 	return filePath
 }
 
+// GetRDBEntries reads the string keys of every database stored in the RDB file at filePath
 func GetRDBEntries(filePath string) ([]RDBTableEntry, error) {
+	defer ServerLatencyMonitor.SampleSince(LATENCY_EVENT_RDB_LOAD, time.Now())
 	f, err := os.Open(filePath)
-
 	if err != nil {
 		return []RDBTableEntry{}, err
 	}
+	defer f.Close()
+	return readRDBEntries(bufio.NewReader(f))
+}
 
-	defer func() {
-		f.Close()
-	}()
-
-	reader := bufio.NewReader(f)
-
-	// advance until keys
-	/* 	_, err = reader.ReadBytes(RDB_DB_SUBSECTION_START_BYTE)
-	   	if err != nil {
-	   		return []RDBTableEntry{},err
-	   	} */
-	_, err = reader.ReadBytes(RDB_HASH_TABLE_START_BYTE)
-	if err != nil {
-		return []RDBTableEntry{}, err
-	}
-	tableSizeBytes := make([]byte, 1)
-	_, err = reader.Read(tableSizeBytes)
+// readRDBEntries reads the string keys of every database stored in the RDB data read by reader
+func readRDBEntries(reader *bufio.Reader) ([]RDBTableEntry, error) {
+	magic := make([]byte, len(RDB_WRITER_MAGIC_STRING))
+	_, err := io.ReadFull(reader, magic)
 	if err != nil {
 		return []RDBTableEntry{}, err
 	}
-	tableExpireSizeBytes := make([]byte, 1)
-	_, err = reader.Read(tableExpireSizeBytes)
-	if err != nil {
-		return []RDBTableEntry{}, err
+	if !strings.HasPrefix(string(magic), "REDIS") {
+		return []RDBTableEntry{}, errors.New("wrong signature trying to load DB from file")
 	}
-	tableSize, _ := binary.ReadUvarint(bytes.NewReader(tableSizeBytes))
-	tableExpireSize, _ := binary.ReadUvarint(bytes.NewReader(tableExpireSizeBytes))
-
-	// fmt.Println("tableSize, expireSize", tableSize, tableExpireSize)
-	tableEntriesExp := []RDBTableEntry{}
-	tableEntriesNoExp := []RDBTableEntry{}
 
+	entries := []RDBTableEntry{}
+	db := 0
+	expiry := int64(0)
 	for {
-		// var buffer bytes.Buffer
-		buf := make([]byte, 1)
-		n, err := reader.Read(buf)
-		if n > 0 {
-			var timeStamp int64
-			b := buf[0]
-			if b == RDB_TIMESTAMP_SECONDS_BYTE {
-				timeStampBytes := make([]byte, RDB_TIMESTAMP_SECONDS_BYTE_LENGTH)
-				_, err := reader.Read(timeStampBytes)
-				if err != nil {
-					return []RDBTableEntry{}, err
-				}
-				buf := bytes.NewReader(timeStampBytes)
-				err = binary.Read(buf, binary.LittleEndian, &timeStamp)
-				if err != nil {
+		opcode, err := reader.ReadByte()
+		if err == io.EOF {
+			// files without an end of file byte are read up to their last complete key
+			return entries, nil
+		}
+		if err != nil {
+			return []RDBTableEntry{}, err
+		}
+
+		switch opcode {
+		case RDB_METADATA_START_BYTE:
+			// auxiliary fields, such as redis-ver, are not used
+			for i := 0; i < 2; i++ {
+				if _, err := readRDBString(reader); err != nil {
 					return []RDBTableEntry{}, err
 				}
-				keyValue, err := getTableKeyAndValue(reader)
-				if err != nil {
-					fmt.Println("Error getting key and value for entry with expiry s")
-					break
-				}
-				tableEntriesExp = append(tableEntriesExp, RDBTableEntry{keyValue[0], keyValue[1], timeStamp * 1000})
 			}
-			if b == RDB_TIMESTAMP_MILLIS_BYTE {
-				timeStampBytes := make([]byte, RDB_TIMESTAMP_MILLIS_BYTE_LENGTH)
-				_, err := reader.Read(timeStampBytes)
-				if err != nil {
-					return []RDBTableEntry{}, err
-				}
-				buf := bytes.NewReader(timeStampBytes)
-				err = binary.Read(buf, binary.LittleEndian, &timeStamp)
-				if err != nil {
+		case RDB_DB_SUBSECTION_START_BYTE:
+			db, err = readRDBLength(reader)
+			if err != nil {
+				return []RDBTableEntry{}, err
+			}
+		case RDB_HASH_TABLE_START_BYTE:
+			// the table sizes are only hints to presize the keyspace
+			for i := 0; i < 2; i++ {
+				if _, err := readRDBLength(reader); err != nil {
 					return []RDBTableEntry{}, err
 				}
-				keyValue, err := getTableKeyAndValue(reader)
-				if err != nil {
-					fmt.Println("Error getting key and value for entry with expiry ms")
-					break
-				}
-				tableEntriesExp = append(tableEntriesExp, RDBTableEntry{keyValue[0], keyValue[1], timeStamp})
-
 			}
-			if b == RDB_STRING_KEY_BYTE {
-				reader.UnreadByte() // go back one byte
-				keyValue, err := getTableKeyAndValue(reader)
-				if err != nil {
-					fmt.Println("Error getting key and value for entry without expiry")
-					break
-				}
-				tableEntriesNoExp = append(tableEntriesNoExp, RDBTableEntry{keyValue[0], keyValue[1], 0})
+		case RDB_TIMESTAMP_SECONDS_BYTE:
+			var seconds uint32
+			err = binary.Read(reader, binary.LittleEndian, &seconds)
+			if err != nil {
+				return []RDBTableEntry{}, err
+			}
+			expiry = int64(seconds) * 1000
+		case RDB_TIMESTAMP_MILLIS_BYTE:
+			err = binary.Read(reader, binary.LittleEndian, &expiry)
+			if err != nil {
+				return []RDBTableEntry{}, err
+			}
+		case RDB_STRING_KEY_BYTE:
+			key, err := readRDBString(reader)
+			if err != nil {
+				return []RDBTableEntry{}, err
+			}
+			value, err := readRDBString(reader)
+			if err != nil {
+				return []RDBTableEntry{}, err
 			}
+			entries = append(entries, RDBTableEntry{key, value, expiry, db})
+			expiry = 0
+		case RDB_END_OF_FILE_BYTE:
+			return entries, nil
+		default:
+			return []RDBTableEntry{}, fmt.Errorf("unsupported RDB value type %d", opcode)
 		}
+	}
+}
 
-		currentTableSize := len(tableEntriesExp) + len(tableEntriesNoExp)
-		if len(tableEntriesExp) == int(tableExpireSize) && currentTableSize == int(tableSize) {
-			fmt.Println("Finished reading for keys and values")
-			break
-		}
+// LoadRDBFile loads the keys of the RDB file, if it exists, into the databases they were saved from.
+// Keys which expired while the server was down are skipped.
+func LoadRDBFile() error {
+	filePath := GetRDBFilePath()
+	entries, err := GetRDBEntries(filePath)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("Reached EOF reading keys and values")
-				return slices.Concat(tableEntriesExp, tableEntriesNoExp), nil
-			}
+	loaded, err := loadRDBEntries(entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d keys from the RDB file %s\n", loaded, filePath)
+	return nil
+}
 
-			fmt.Println("Error reading keys with ms expire")
-			return []RDBTableEntry{}, err
+// loadRDBEntries stores the entries read from an RDB file in their databases, skipping those which
+// expired, and returns the number of keys stored
+func loadRDBEntries(entries []RDBTableEntry) (int, error) {
+	now := time.Now().UnixMilli()
+	loaded := 0
+	for _, entry := range entries {
+		if entry.db >= ServerDatabases.Len() {
+			return loaded, fmt.Errorf("the RDB file holds keys of database %d, but only %d databases are configured", entry.db, ServerDatabases.Len())
+		}
+		if entry.expiry != 0 && entry.expiry <= now {
+			continue
 		}
+		ServerDatabases.DB(entry.db).Set(entry.key, NewMemoryItem(NewStringValue(entry.value), entry.expiry))
+		loaded++
 	}
-
-	return slices.Concat(tableEntriesExp, tableEntriesNoExp), nil
+	return loaded, nil
 }
 
-// receives a reader at most one byte before the key type byte
-func getTableKeyAndValue(r *bufio.Reader) ([2]string, error) {
-	var keyValue []string
-	_, err := r.ReadBytes(RDB_STRING_KEY_BYTE)
+// readRDBString reads a length-prefixed string, or an integer encoded as a string
+func readRDBString(r *bufio.Reader) (string, error) {
+	first, err := r.Peek(1)
 	if err != nil {
-		return [2]string{}, err
+		return "", err
 	}
 
-	for j := 0; j < 2; j++ {
-		sizeBytes := make([]byte, 1)
-		_, err = r.Read(sizeBytes)
-		if err != nil {
-			fmt.Println("failed to read size")
-			return [2]string{}, err
+	if first[0]>>6 == 0b11 {
+		r.ReadByte()
+		var integer int64
+		switch first[0] {
+		case 0xC0:
+			var value int8
+			err = binary.Read(r, binary.LittleEndian, &value)
+			integer = int64(value)
+		case 0xC1:
+			var value int16
+			err = binary.Read(r, binary.LittleEndian, &value)
+			integer = int64(value)
+		case 0xC2:
+			var value int32
+			err = binary.Read(r, binary.LittleEndian, &value)
+			integer = int64(value)
+		default:
+			return "", fmt.Errorf("unsupported RDB string encoding %d", first[0])
 		}
-
-		valueType, bitRange, sizeEncodingBytes, err := decodeTypeAttrs(sizeBytes[0])
 		if err != nil {
-			fmt.Println("failed to decode type attributes for ", sizeBytes[0])
-			return [2]string{}, err
-		}
-		if valueType != "string" {
-			return [2]string{}, errors.New("value is not of type string")
+			return "", err
 		}
+		return strconv.FormatInt(integer, 10), nil
+	}
+
+	length, err := readRDBLength(r)
+	if err != nil {
+		return "", err
+	}
+	stringBytes := make([]byte, length)
+	_, err = io.ReadFull(r, stringBytes)
+	if err != nil {
+		return "", err
+	}
+	return string(stringBytes), nil
+}
 
-		var value string
-		stringLength, err := getStringLength(sizeBytes, bitRange)
+// readRDBLength reads a length-encoded size. Special string encodings, such as integers stored as
+// strings, are reported as errors.
+func readRDBLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch first >> 6 {
+	case 0b00:
+		return int(first & 0b00111111), nil
+	case 0b01:
+		next, err := r.ReadByte()
 		if err != nil {
-			fmt.Println("failed to get string length")
-			return [2]string{}, err
+			return 0, err
 		}
-
-		stringBytes := make([]byte, stringLength)
-		_, err = r.Read(stringBytes)
+		return int(first&0b00111111)<<8 | int(next), nil
+	case 0b10:
+		lengthBytes := make([]byte, 4)
+		_, err := io.ReadFull(r, lengthBytes)
 		if err != nil {
-			fmt.Println("failed to read bytes for string")
-			return [2]string{}, err
+			return 0, err
 		}
-
-		fmt.Println("stringBytes, length, sizeEncodingBytes", len(stringBytes), stringLength, sizeEncodingBytes)
-		value = decodeString(stringBytes, stringLength, 0)
-		keyValue = append(keyValue, value)
-
+		return int(binary.BigEndian.Uint32(lengthBytes)), nil
+	default:
+		return 0, errors.New("value is not of type string")
 	}
-
-	fmt.Println("keyValue", keyValue)
-	return [2]string{keyValue[0], keyValue[1]}, nil
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RDB writer constants
+const (
+	RDB_WRITER_MAGIC_STRING = "REDIS0011"
+	RDB_WRITER_VERSION      = REDIS_VERSION
+)
+
+// RDB length encoding limits
+const (
+	RDB_6BIT_LENGTH_LIMIT  = 1 << 6
+	RDB_14BIT_LENGTH_LIMIT = 1 << 14
+	RDB_14BIT_LENGTH_FLAG  = 0b01000000
+	RDB_32BIT_LENGTH_FLAG  = 0b10000000
+)
+
+// SaveRDBFile writes every non-expired key to an RDB file at filePath. The file is first written
+// to a temporary file in the same directory and then renamed, so a failed save never leaves a
+// truncated file behind. Keys holding a type the RDB file cannot encode yet are left out with a warning.
+func SaveRDBFile(filePath string) error {
+	defer ServerLatencyMonitor.SampleSince(LATENCY_EVENT_RDB_SAVE, time.Now())
+	err := saveRDBFile(filePath)
+	Stats.RecordSave(err)
+	return err
+}
+
+func saveRDBFile(filePath string) error {
+	start := time.Now()
+	tempPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	dbs := ServerDatabases.All()
+	err = writeRDB(f, dbs, (*ServerMemory).ForEach, func(db int, key string, memItem MemoryItem) {
+		fmt.Printf("Skipping key %s: values of type %s cannot be saved in the RDB file yet\n", key, memItem.TypeName())
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	fmt.Printf("DB saved on disk to %s in %s\n", filePath, time.Since(start))
+	return nil
+}
+
+// writeRDB writes the databases dbs in the RDB format to w, reading the keys of each of them through
+// forEach. Keys holding a type the RDB file cannot encode yet are passed to skipped instead.
+func writeRDB(w io.Writer, dbs []*ServerMemory, forEach func(db *ServerMemory, fn func(key string, item MemoryItem)), skipped func(db int, key string, item MemoryItem)) error {
+	writer := bufio.NewWriter(w)
+	now := time.Now().UnixMilli()
+
+	writer.WriteString(RDB_WRITER_MAGIC_STRING)
+	writer.WriteByte(RDB_METADATA_START_BYTE)
+	writeRDBString(writer, "redis-ver")
+	writeRDBString(writer, RDB_WRITER_VERSION)
+
+	for index, db := range dbs {
+		writeRDBDatabase(writer, index, db, now, forEach, skipped)
+	}
+
+	writer.WriteByte(RDB_END_OF_FILE_BYTE)
+	// a zero checksum tells readers that checksums are disabled
+	writer.Write(make([]byte, 8))
+
+	return writer.Flush()
+}
+
+// writeRDBDatabase writes the non-expired keys of the database at index, or nothing if it has none.
+// Only strings can be encoded yet, keys of other types are passed to skipped so they never make a
+// save, and so a shutdown, impossible.
+func writeRDBDatabase(writer *bufio.Writer, index int, db *ServerMemory, now int64, forEach func(db *ServerMemory, fn func(key string, item MemoryItem)), skipped func(db int, key string, item MemoryItem)) {
+	type rdbEntry struct {
+		key     string
+		value   string
+		expires int64
+	}
+	entries := []rdbEntry{}
+	expiresCount := 0
+
+	forEach(db, func(key string, memItem MemoryItem) {
+		if memItem.expires != 0 && memItem.expires <= now {
+			return
+		}
+
+		value, valueType := memItem.GetValueDirectly()
+		var stringValue string
+		switch valueType {
+		case STRING:
+			stringValue = string(*(value.(*StringValue)))
+		case INT:
+			stringValue = fmt.Sprint(int(*(value.(*IntegerValue))))
+		default:
+			skipped(index, key, memItem)
+			return
+		}
+
+		entries = append(entries, rdbEntry{key, stringValue, memItem.expires})
+		if memItem.expires != 0 {
+			expiresCount++
+		}
+	})
+	if len(entries) == 0 {
+		return
+	}
+
+	writer.WriteByte(RDB_DB_SUBSECTION_START_BYTE)
+	writeRDBLength(writer, index)
+	writer.WriteByte(RDB_HASH_TABLE_START_BYTE)
+	writeRDBLength(writer, len(entries))
+	writeRDBLength(writer, expiresCount)
+
+	for _, entry := range entries {
+		if entry.expires != 0 {
+			writer.WriteByte(RDB_TIMESTAMP_MILLIS_BYTE)
+			binary.Write(writer, binary.LittleEndian, entry.expires)
+		}
+		writer.WriteByte(RDB_STRING_KEY_BYTE)
+		writeRDBString(writer, entry.key)
+		writeRDBString(writer, entry.value)
+	}
+}
+
+func writeRDBLength(writer *bufio.Writer, length int) {
+	switch {
+	case length < RDB_6BIT_LENGTH_LIMIT:
+		writer.WriteByte(byte(length))
+	case length < RDB_14BIT_LENGTH_LIMIT:
+		writer.WriteByte(byte(length>>8) | RDB_14BIT_LENGTH_FLAG)
+		writer.WriteByte(byte(length))
+	default:
+		writer.WriteByte(RDB_32BIT_LENGTH_FLAG)
+		binary.Write(writer, binary.BigEndian, uint32(length))
+	}
+}
+
+func writeRDBString(writer *bufio.Writer, s string) {
+	writeRDBLength(writer, len(s))
+	writer.WriteString(s)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"net"
+	"strings"
 )
 
 // Default hosts and addresses
 const (
-	DEFAULT_HOST         = "localhost"
+	// every IPv4 address, and every IPv6 address if the host supports IPv6
+	DEFAULT_BIND         = "* -::*"
 	DEFAULT_PORT         = 6379
 	DEFAULT_MASTER       = ""
 	DEFAULT_HOST_ADDRESS = "0.0.0.0"
+	REPLICAOF_NO_ONE     = "no one"
 )
 
 // Constants for server struct fields
@@ -24,26 +27,35 @@ const (
 )
 
 type ServerStatus struct {
-	XReadBlock chan bool
+	Lifecycle *ServerLifecycle
 }
 
 type RedisServer interface {
 	Start() error
 	ReplicaInfo() ReplicaInfo
-	RunCommand(cmp CommandComponents, conn net.Conn, trx *Transaction) error
-	GetRDBConfig() map[string]string
+	// ReplicationInfo returns the fields of the replication section of INFO
+	ReplicationInfo() []string
+	// RunCommand runs a command on behalf of client, writing its replies to conn
+	RunCommand(cmp CommandComponents, conn net.Conn, client *Client) error
 	GetStatus() *ServerStatus
+	Shutdown(options ShutdownOptions) error
+	// PropagateExpired queues the propagation of the removal of a key of the database at db which
+	// expired. It is called with the key locked, so it must not access the keyspace nor do any I/O.
+	PropagateExpired(db int, key string)
 }
 
-func CreateRedisServer(port int, replicaOf string, rdbDir, rdbFileName string) (RedisServer, error) {
-	if replicaOf != "" {
+// CreateRedisServer creates a master or a replica, depending on the server configuration
+func CreateRedisServer() (RedisServer, error) {
+	port := ServerConfig.GetInt(CONFIG_PORT)
+	replicaOf := ServerConfig.Get(CONFIG_REPLICAOF)
+	if replicaOf != "" && !strings.EqualFold(replicaOf, REPLICAOF_NO_ONE) {
 		server, err := NewSlaveServer(port, replicaOf)
 		if err != nil {
 			return nil, err
 		}
-		return &server, nil
+		return server, nil
 	}
 
-	server := NewMasterServer(port, rdbDir, rdbFileName)
-	return &server, nil
+	server := NewMasterServer(port)
+	return server, nil
 }
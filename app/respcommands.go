@@ -3,10 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
-	"os"
-	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,24 +12,56 @@ import (
 
 // Supported commands
 const (
-	PING     = "PING"
-	ECHO     = "ECHO"
-	INFO     = "INFO"
-	SET      = "SET"
-	GET      = "GET"
-	REPLCONF = "REPLCONF"
-	PSYNC    = "PSYNC"
-	WAIT     = "WAIT"
-	CONFIG   = "CONFIG"
-	KEYS     = "KEYS"
-	TYPE     = "TYPE"
-	XADD     = "XADD"
-	XRANGE   = "XRANGE"
-	XREAD    = "XREAD"
-	INCR     = "INCR"
-	MULTI    = "MULTI"
-	EXEC     = "EXEC"
-	DISCARD  = "DISCARD"
+	PING        = "PING"
+	ECHO        = "ECHO"
+	INFO        = "INFO"
+	SET         = "SET"
+	GET         = "GET"
+	REPLCONF    = "REPLCONF"
+	PSYNC       = "PSYNC"
+	WAIT        = "WAIT"
+	CONFIG      = "CONFIG"
+	KEYS        = "KEYS"
+	TYPE        = "TYPE"
+	XADD        = "XADD"
+	XRANGE      = "XRANGE"
+	XREAD       = "XREAD"
+	INCR        = "INCR"
+	MULTI       = "MULTI"
+	EXEC        = "EXEC"
+	DISCARD     = "DISCARD"
+	COMMAND     = "COMMAND"
+	SHUTDOWN    = "SHUTDOWN"
+	SLOWLOG     = "SLOWLOG"
+	LATENCY     = "LATENCY"
+	MONITOR     = "MONITOR"
+	CLIENT      = "CLIENT"
+	AUTH        = "AUTH"
+	ACL         = "ACL"
+	SELECT      = "SELECT"
+	MOVE        = "MOVE"
+	SWAPDB      = "SWAPDB"
+	DBSIZE      = "DBSIZE"
+	FLUSHDB     = "FLUSHDB"
+	FLUSHALL    = "FLUSHALL"
+	DEL         = "DEL"
+	UNLINK      = "UNLINK"
+	EXISTS      = "EXISTS"
+	RENAME      = "RENAME"
+	RENAMENX    = "RENAMENX"
+	COPY        = "COPY"
+	TOUCH       = "TOUCH"
+	RANDOMKEY   = "RANDOMKEY"
+	EXPIRE      = "EXPIRE"
+	PEXPIRE     = "PEXPIRE"
+	EXPIREAT    = "EXPIREAT"
+	PEXPIREAT   = "PEXPIREAT"
+	TTL         = "TTL"
+	PTTL        = "PTTL"
+	EXPIRETIME  = "EXPIRETIME"
+	PEXPIRETIME = "PEXPIRETIME"
+	PERSIST     = "PERSIST"
+	SCAN        = "SCAN"
 )
 
 // Command types --
@@ -44,7 +74,24 @@ type RespCommand struct {
 	argLen int
 	// signature string
 	Type    string
-	Execute func([]string, RedisServer) (string, error)
+	Execute func([]string, RedisServer, *Client) (string, error)
+	// arity follows the Redis convention: the exact number of arguments including the
+	// command name, or the negated minimum number of arguments for variadic commands
+	arity int
+	// flags and categories are reported by COMMAND and used for command filtering
+	flags      []string
+	categories []string
+	// firstKey, lastKey and keyStep are the positions of the key arguments, counting the
+	// command name as position 0. A negative lastKey counts backwards from the last argument
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// getKeys returns the key positions for commands whose keys cannot be described by
+	// firstKey, lastKey and keyStep alone
+	getKeys func(args []string) []int
+	summary string
+	since   string
+	group   string
 }
 
 type CommandComponents struct {
@@ -62,14 +109,26 @@ func (c *RespCommand) GetArgLen() int {
 
 var (
 	Ping = RespCommand{
-		argLen: 1,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     1,
+		arity:      -1,
+		flags:      []string{"fast"},
+		categories: []string{"@fast", "@connection"},
+		summary:    "Returns the server's liveliness response.",
+		since:      "1.0.0",
+		group:      "connection",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			return ToRespSimpleString("PONG"), nil
 		},
 	}
 	Echo = RespCommand{
-		argLen: 2,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     2,
+		arity:      2,
+		flags:      []string{"fast"},
+		categories: []string{"@fast", "@connection"},
+		summary:    "Returns the given string.",
+		since:      "1.0.0",
+		group:      "connection",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			if len(args) == 0 {
 				return ToRespBulkString(""), nil
 			}
@@ -77,9 +136,18 @@ var (
 		},
 	}
 	Set = RespCommand{
-		argLen: 3,
-		Type:   WRITE,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     3,
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "denyoom"},
+		categories: []string{"@write", "@string", "@slow"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		since:      "1.0.0",
+		group:      "string",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			if len(args) < 2 {
 				return "", errors.New("insufficient arguments")
 			}
@@ -118,37 +186,37 @@ var (
 				itemExpires = time.Now().UnixMilli() + int64(expiresInMs)
 			}
 
-			var memoryItemValue MemoryItemValue
-			numericValue, err := strconv.Atoi(stringValueArg)
-			if err == nil {
-				value := IntegerValue(numericValue)
-				memoryItemValue = &value
-			} else {
-				value := StringValue(stringValueArg)
-				memoryItemValue = &value
-
-			}
-
-			Memory[key] = MemoryItem{memoryItemValue, itemExpires}
+			client.Database().Set(key, MemoryItem{NewStringValue(stringValueArg), itemExpires})
 			return ToRespSimpleString("OK"), nil
 		},
 	}
 	Get = RespCommand{
-		argLen: 2,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     2,
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@read", "@string", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the string value of a key.",
+		since:      "1.0.0",
+		group:      "string",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			key := args[0]
-			memItem, exists := Memory[key]
+			memItem, exists := client.Database().Get(key)
 
 			if exists {
 				_, err := memItem.GetValue()
 				if err != nil {
 					if err == ErrExpiredKey {
+						Stats.RecordKeyspaceLookup(false)
 						return NULL_BULK_STRING, nil
 					}
 					fmt.Printf("Failed to get key %s: %v\n", key, err)
 					return "", err
 				}
 
+				Stats.RecordKeyspaceLookup(true)
 				respString, err := memItem.ToRespString()
 				if err != nil {
 					return "", err
@@ -156,105 +224,120 @@ var (
 				return respString, nil
 			}
 
-			filePath := GetRDBFilePath(server)
-			if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
-				return NULL_BULK_STRING, nil
-			} else if err != nil {
-				return "", err
-			}
-
-			dbEntries, err := GetRDBEntries(filePath)
-			if err != nil {
-				if err == io.EOF {
-					return NULL_BULK_STRING, nil
-				}
-				return "", err
-			}
-
-			for _, entry := range dbEntries {
-				if key != entry.key {
-					continue
-				}
-
-				expires := int64(0)
-				if entry.expiry != 0 {
-					expires = entry.expiry
-				}
-				memValue := StringValue(entry.value)
-				memItem := MemoryItem{&memValue, expires}
-				_, err := memItem.GetValue()
-				if err != nil {
-					if err == ErrExpiredKey {
-						return NULL_BULK_STRING, nil
-					}
-					return "", err
-				}
-
-				return ToRespBulkString(entry.value), nil
-			}
-
+			Stats.RecordKeyspaceLookup(false)
 			return NULL_BULK_STRING, nil
 
 		},
 	}
 	Info = RespCommand{
-		argLen: 2,
-		Execute: func(args []string, server RedisServer) (string, error) {
-			infoType := args[0]
-
-			switch infoType {
-			case REPLICATION:
-				response := []string{"#Replication"}
-				replicaInfo := server.ReplicaInfo()
-				valueOfReplInfo := reflect.ValueOf(replicaInfo)
-				typeOfReplInfo := reflect.TypeOf(replicaInfo)
-
-				for i := 0; i < valueOfReplInfo.NumField(); i++ {
-					field := valueOfReplInfo.Field(i)
-					fieldName := typeOfReplInfo.Field(i).Name
-					response = append(response, fmt.Sprintf("%s:%v", CamelCaseToSnakeCase(fieldName), field))
-				}
-
-				return ToRespBulkString(strings.Join(response, "\r\n")), nil
-			default:
-				return ToRespSimpleString("unsupported INFO type"), nil
-
-			}
+		argLen:     2,
+		arity:      -1,
+		flags:      []string{"loading", "stale"},
+		categories: []string{"@slow", "@dangerous"},
+		summary:    "Returns information and statistics about the server.",
+		since:      "1.0.0",
+		group:      "server",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
+			return ToRespBulkString(GenerateInfo(server, args)), nil
 		},
 	}
 	Config = RespCommand{
-		Execute: func(args []string, server RedisServer) (string, error) {
-			concatArgs := strings.Join(args, " ")
-			configRdb, _ := regexp.MatchString(`^`+GET+` `+`(`+RDB_DIR_ARG+`|`+RDB_FILENAME_ARG+`)$`, concatArgs)
+		arity:      -2,
+		flags:      []string{"admin", "noscript", "loading", "stale"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "A container for server configuration commands.",
+		since:      "2.0.0",
+		group:      "server",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
+			if len(args) == 0 {
+				return ToRespError(errors.New("wrong number of arguments for 'config' command")), nil
+			}
 
-			switch {
-			case configRdb:
-				rdbArg := args[1]
-				return ToRespBulkStringArray(rdbArg, server.GetRDBConfig()[rdbArg]), nil
+			subcommand := strings.ToUpper(args[0])
+			switch subcommand {
+			case CONFIG_SUBCOMMAND_GET:
+				if len(args) < 2 {
+					return ToRespError(errors.New("wrong number of arguments for 'config|get' command")), nil
+				}
+				names := []string{}
+				for _, pattern := range args[1:] {
+					for _, name := range ServerConfig.Match(pattern) {
+						if !slices.Contains(names, name) {
+							names = append(names, name)
+						}
+					}
+				}
+				response := []string{}
+				for _, name := range names {
+					response = append(response, name, ServerConfig.Get(name))
+				}
+				return ToRespBulkStringArray(response...), nil
+			case CONFIG_SUBCOMMAND_SET:
+				if len(args) < 3 || len(args)%2 == 0 {
+					return ToRespError(errors.New("wrong number of arguments for 'config|set' command")), nil
+				}
+				names, values := []string{}, []string{}
+				for i := 1; i < len(args); i += 2 {
+					names = append(names, args[i])
+					values = append(values, args[i+1])
+				}
+				err := ServerConfig.SetMany(names, values)
+				if err != nil {
+					return ToRespError(err), nil
+				}
+				return ToRespSimpleString("OK"), nil
+			case CONFIG_SUBCOMMAND_RESETSTAT:
+				Stats.Reset()
+				return ToRespSimpleString("OK"), nil
+			case CONFIG_SUBCOMMAND_REWRITE:
+				err := ServerConfig.RewriteConfigFile()
+				if err != nil {
+					return ToRespError(fmt.Errorf("Rewriting config file: %v", err)), nil
+				}
+				return ToRespSimpleString("OK"), nil
 			default:
-				return ToRespBulkStringArray(""), nil
+				return ToRespError(fmt.Errorf("unknown subcommand '%s'. Try CONFIG HELP.", args[0])), nil
 			}
 		},
 	}
 	ReplConf = RespCommand{
-		argLen: 1,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     1,
+		arity:      -1,
+		flags:      []string{"admin", "noscript", "loading", "stale"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "An internal command for configuring the replication stream.",
+		since:      "3.0.0",
+		group:      "server",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			return ToRespSimpleString(OK), nil
 		},
 	}
 	Psync = RespCommand{
-		argLen: 1,
-		Execute: func(args []string, server RedisServer) (string, error) {
+		argLen:     1,
+		arity:      -3,
+		flags:      []string{"admin", "noscript", "no_multi"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "An internal command used in replication.",
+		since:      "2.8.0",
+		group:      "server",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			return BuildPsyncResponse(server.ReplicaInfo().masterReplid), nil
 		},
 	}
 	Wait = RespCommand{
-		Execute: func(args []string, server RedisServer) (string, error) {
+		arity:      3,
+		flags:      []string{"noscript"},
+		categories: []string{"@slow", "@connection"},
+		summary:    "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.",
+		since:      "3.0.0",
+		group:      "generic",
+		Execute: func(args []string, server RedisServer, client *Client) (string, error) {
 			masterServer, ok := server.(*RedisMasterServer)
 			if !ok {
 				return ToRespInteger(0), nil
 			}
-			if len(masterServer.replicas) < 1 {
+			replicas := masterServer.Replicas()
+			if len(replicas) < 1 {
 				return ToRespInteger(0), nil
 			}
 			numberOfReplicas, err := strconv.Atoi(args[0])
@@ -266,35 +349,32 @@ var (
 				return "", errors.New("invalid timeout value for " + WAIT)
 			}
 
+			masterServer.mu.Lock()
 			prevHistoryItem := masterServer.history.GetModifiableEntry(len(masterServer.history) - 2)
+			masterServer.mu.Unlock()
 
 			if prevHistoryItem.RespCommand.Type != WRITE {
-				return ToRespInteger(len(masterServer.replicas)), nil
+				return ToRespInteger(len(replicas)), nil
 			}
 
-			ackChan := make(chan bool)
+			// buffered so acknowledgements arriving while WAIT checks the count are not dropped
+			ackChan := make(chan bool, len(replicas))
 			// handle the acknowledge update during command execution
 			masterServer.SetAcknowledgeItem(prevHistoryItem, ackChan)
 
-			for _, replica := range masterServer.replicas {
-				_, err := replica.conn.Write([]byte(ToRespBulkStringArray(REPLCONF, GETACK, GETACK_FROM_REPLICA_ARG)))
-				if err != nil {
-					fmt.Println("Failed write GETACK to " + replica.conn.RemoteAddr().String())
-					continue
-				}
-			}
+			masterServer.propagateCommand(ToRespBulkStringArray(REPLCONF, GETACK, GETACK_FROM_REPLICA_ARG))
 
 			timer := time.After(time.Duration(timeoutMillis) * time.Millisecond)
 
 			for {
 				select {
 				case <-ackChan:
-					if masterServer.waitAckFor.Acks == numberOfReplicas {
+					if masterServer.AcknowledgedCount() == numberOfReplicas {
 						masterServer.SetAcknowledgeItem(nil, nil)
 						return ToRespInteger(numberOfReplicas), nil
 					}
 				case <-timer:
-					lastAcksRead := masterServer.waitAckFor.Acks
+					lastAcksRead := masterServer.AcknowledgedCount()
 					masterServer.SetAcknowledgeItem(nil, nil)
 					return ToRespInteger(lastAcksRead), nil
 				}
@@ -303,56 +383,61 @@ var (
 		},
 	}
 	Save = RespCommand{
-		Execute: func(s []string, rs RedisServer) (string, error) {
+		Execute: func(s []string, rs RedisServer, client *Client) (string, error) {
 			return "", nil
 		},
 	}
 	Keys = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
-			pattern := args[0]
-			filePath := GetRDBFilePath(rs)
-
-			switch pattern {
-			case "*":
-				entries, err := GetRDBEntries(filePath)
-				if err != nil {
-					return "", err
-				}
-				keys := []string{}
-				for _, entry := range entries {
-					keys = append(keys, entry.key)
-				}
-
-				fmt.Println("keys:", keys)
-				return ToRespBulkStringArray(keys...), nil
-			default:
-				return ToRespBulkStringArray(""), nil
-			}
-
+		arity:      2,
+		flags:      []string{"readonly"},
+		categories: []string{"@keyspace", "@read", "@slow", "@dangerous"},
+		summary:    "Returns all key names that match a pattern.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteKeys(args, client)
 		},
 	}
 	Type = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Determines the type of value stored at a key.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			key := args[0]
-			memItem, exists := Memory[key]
+			memItem, exists := client.Database().Get(key)
 			if !exists {
 				return ToRespSimpleString(EMPTY_KEY_TYPE), nil
 			}
 			_, err := memItem.GetValue()
 			if err != nil {
 				if err == ErrExpiredKey {
-					return NULL_BULK_STRING, nil
+					return ToRespSimpleString(EMPTY_KEY_TYPE), nil
 				} else {
 					fmt.Printf("Failed to get key %s: %v\n", key, err)
 					return "", err
 				}
 			}
-			_, valueType := memItem.GetValueDirectly()
-			return ToRespSimpleString(valueType), nil
+			return ToRespSimpleString(memItem.TypeName()), nil
 		},
 	}
 	XAdd = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		Type:       WRITE,
+		arity:      -5,
+		flags:      []string{"write", "denyoom", "fast"},
+		categories: []string{"@write", "@stream", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Appends a new message to a stream. Creates the key if it doesn't exist.",
+		since:      "5.0.0",
+		group:      "stream",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			concatArgs := strings.Join(args, " ")
 			simpleStreamRegExp := `(\w+){1} (([0-9]+-([0-9]|\*))+|\*{1}) (\w+ )+\w+$`
 			isSimpleStream, _ := regexp.MatchString(simpleStreamRegExp, concatArgs)
@@ -360,20 +445,13 @@ var (
 			switch {
 			case isSimpleStream:
 				key, idArg := args[0], args[1]
-				newId, err := GenerateStreamId(key, idArg)
+				newId, err := client.Database().AddStreamItem(key, idArg, args[2:], client.expired)
 				if err != nil {
 					msg := CapitalizeFirstCharacter(err.Error())
 					return ToRespError(errors.New(msg)), nil
 				}
 
-				streamItem := NewStreamItem(newId, args[2:])
-
-				Memory.AddStreamItem(key, streamItem)
-
-				status := rs.GetStatus()
-				if status.XReadBlock != nil {
-					status.XReadBlock <- true
-				}
+				ServerStreamWaiters.Notify(client.DB(), key)
 
 				return ToRespBulkString(newId), nil
 			default:
@@ -383,9 +461,18 @@ var (
 		},
 	}
 	XRange = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      -4,
+		flags:      []string{"readonly"},
+		categories: []string{"@read", "@stream", "@slow"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the messages from a stream within a range of IDs.",
+		since:      "5.0.0",
+		group:      "stream",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			key, startId, endId := args[0], args[1], args[2]
-			memItem, ok := Memory[key]
+			memItem, ok := client.Database().Get(key)
 
 			if !ok {
 				return "", fmt.Errorf("stream with key %s does not exist", key)
@@ -422,7 +509,14 @@ var (
 		},
 	}
 	XRead = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      -4,
+		flags:      []string{"readonly", "blocking", "movablekeys"},
+		categories: []string{"@read", "@stream", "@slow", "@blocking"},
+		getKeys:    xreadKeys,
+		summary:    "Returns messages from multiple streams with IDs greater than the ones requested.",
+		since:      "5.0.0",
+		group:      "stream",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			concatArgs := strings.Join(args, " ")
 			blockRegex := `^block \d+ streams \w+ (([0-9]+-([0-9]|\*))+|\*{1}|\${1})$`
 			streamReadRegex := `^streams (\w+ )+((([0-9]+-([0-9]|\*))+|\*{1}) )*(([0-9]+-([0-9]|\*))+|\*{1})$`
@@ -436,7 +530,7 @@ var (
 				for keyIndex := 1; keyIndex <= numKeys; keyIndex++ {
 					idIndex := numKeys + keyIndex
 					key, id := args[keyIndex], args[idIndex]
-					stream, err := Memory.LookupStream(key)
+					stream, err := client.Database().LookupStream(key)
 					if err != nil {
 						return NULL_BULK_STRING, err
 					}
@@ -462,7 +556,12 @@ var (
 					return "", err
 				}
 
-				stream, err := Memory.LookupStream(key)
+				// the waiter is registered before the stream is read, so an entry added in between still wakes it up
+				db := client.DB()
+				waiter := ServerStreamWaiters.Add(db, key)
+				defer ServerStreamWaiters.Remove(db, key, waiter)
+
+				stream, err := client.Database().LookupStream(key)
 				if err != nil {
 					return "", err
 				}
@@ -471,16 +570,26 @@ var (
 					lastKnownIndex -= 1
 				}
 
-				status := rs.GetStatus()
 				// onlyNewReads := strings.HasSuffix(concatArgs, XREAD_ONLY_NEW)
 
-				if blockTime == 0 {
-					status.XReadBlock = make(chan bool)
-					<-status.XReadBlock
-				} else {
-					duration := time.Duration(blockTime.Milliseconds()) * time.Millisecond
-					time.Sleep(duration)
+				Stats.BlockedClients.Add(1)
+				client.blocked.Store(true)
+				switch {
+				case client.Transaction.Conn != nil:
+					// EXEC never blocks, which would stop every write until the transaction ends, so
+					// the read acts as if it timed out
+				case blockTime == 0:
+					<-waiter
+				default:
+					timer := time.NewTimer(blockTime)
+					select {
+					case <-waiter:
+					case <-timer.C:
+					}
+					timer.Stop()
 				}
+				Stats.BlockedClients.Add(-1)
+				client.blocked.Store(false)
 
 				var index int
 
@@ -493,13 +602,11 @@ var (
 					}
 				}
 
-				stream, _ = Memory.LookupStream(key)
+				stream, _ = client.Database().LookupStream(key)
 				if len(stream) <= index+1 {
 					return NULL_BULK_STRING, nil
 				}
 
-				status.XReadBlock = nil
-
 				streamItem := stream[index+1]
 				return ARRAY + "1" + PROTOCOL_TERMINATOR + ARRAY + "2" + PROTOCOL_TERMINATOR + BULK_STRING + strconv.Itoa(len(key)) + PROTOCOL_TERMINATOR + key + PROTOCOL_TERMINATOR + StreamItemsToRespArray([]Stream{streamItem}), nil
 			}
@@ -508,66 +615,551 @@ var (
 		},
 	}
 	Incr = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		Type:       WRITE,
+		arity:      2,
+		flags:      []string{"write", "denyoom", "fast"},
+		categories: []string{"@write", "@string", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		since:      "1.0.0",
+		group:      "string",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			key := args[0]
-			memItem, exists := Memory[key]
+			updatedInt := 0
+			var err error
+
+			// the increment happens under the key's lock so concurrent INCRs are never lost
+			client.Database().Update(key, func(memItem MemoryItem, exists bool) (MemoryItem, bool) {
+				if !exists || client.expired(memItem) {
+					updatedInt = 1
+					integerValue := IntegerValue(updatedInt)
+					return MemoryItem{&integerValue, 0}, true
+				}
 
-			if !exists {
-				integerValue := IntegerValue(1)
-				Memory[key] = MemoryItem{&integerValue, 0}
-				return ToRespInteger(1), nil
-			}
+				value, valueType := memItem.GetValueDirectly()
 
-			value, valueType := memItem.GetValueDirectly()
+				if valueType != INT {
+					err = errors.New("value is not an integer or out of range")
+					return memItem, false
+				}
+
+				// values may be read concurrently by other commands, so a new one is stored instead of mutating it
+				updatedInt = int(*(value.(*IntegerValue))) + 1
+				integerValue := IntegerValue(updatedInt)
+				return MemoryItem{&integerValue, memItem.expires}, true
+			})
 
-			if valueType != INT {
-				err := errors.New("value is not an integer or out of range")
+			if err != nil {
 				return ToRespError(err), nil
 			}
-
-			integerValue := value.(*IntegerValue)
-			updatedInt := int(*integerValue) + 1
-			*integerValue = IntegerValue(updatedInt)
-			Memory[key] = MemoryItem{integerValue, memItem.expires}
 			return ToRespInteger(updatedInt), nil
 		},
 	}
 	Multi = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      1,
+		flags:      []string{"noscript", "loading", "stale", "fast"},
+		categories: []string{"@fast", "@transaction"},
+		summary:    "Starts a transaction.",
+		since:      "1.2.0",
+		group:      "transactions",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			return ToRespSimpleString(OK), nil
 		},
 	}
 	Exec = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      1,
+		flags:      []string{"noscript", "loading", "stale", "skip_slowlog"},
+		categories: []string{"@slow", "@transaction"},
+		summary:    "Executes all commands in a transaction.",
+		since:      "1.2.0",
+		group:      "transactions",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			return "", nil
 		},
 	}
+	Shutdown = RespCommand{
+		arity:      -1,
+		flags:      []string{"admin", "noscript", "loading", "stale", "no_multi"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+		since:      "1.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			options, err := ParseShutdownOptions(args)
+			if err != nil {
+				return ToRespError(err), nil
+			}
+			options.fromClient = true
+
+			err = rs.Shutdown(options)
+			if err != nil {
+				return ToRespError(err), nil
+			}
+
+			// the connection has been closed, there is no one to reply to
+			return "", nil
+		},
+	}
+	Slowlog = RespCommand{
+		arity:      -2,
+		flags:      []string{"admin", "loading", "stale"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "A container for slow log commands.",
+		since:      "2.2.12",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteSlowLog(args)
+		},
+	}
+	Latency = RespCommand{
+		arity:      -2,
+		flags:      []string{"admin", "noscript", "loading", "stale"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "A container for latency diagnostics commands.",
+		since:      "2.8.13",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteLatency(args)
+		},
+	}
+	Monitor = RespCommand{
+		arity:      1,
+		flags:      []string{"admin", "noscript", "loading", "stale", "no_multi"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "Listens for all requests received by the server in real-time.",
+		since:      "1.0.0",
+		group:      "server",
+		// the connection is put in monitor mode by DispatchCommand, which owns it
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ToRespSimpleString(OK), nil
+		},
+	}
+	ClientCommand = RespCommand{
+		arity:      -2,
+		flags:      []string{"noscript", "loading", "stale"},
+		categories: []string{"@slow", "@connection"},
+		summary:    "A container for client connection commands.",
+		since:      "2.4.0",
+		group:      "connection",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteClient(args, client)
+		},
+	}
+	Auth = RespCommand{
+		arity:      -2,
+		flags:      []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"},
+		categories: []string{"@fast", "@connection"},
+		summary:    "Authenticates the connection.",
+		since:      "1.0.0",
+		group:      "connection",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteAuth(args, client)
+		},
+	}
+	Select = RespCommand{
+		arity:      2,
+		flags:      []string{"loading", "stale", "fast"},
+		categories: []string{"@keyspace", "@fast"},
+		summary:    "Changes the selected database.",
+		since:      "1.0.0",
+		group:      "connection",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteSelect(args, client)
+		},
+	}
+	Move = RespCommand{
+		Type:       WRITE,
+		arity:      3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Moves a key to another database.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteMove(args, client)
+		},
+	}
+	SwapDB = RespCommand{
+		Type:       WRITE,
+		arity:      3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast", "@dangerous"},
+		summary:    "Swaps two Redis databases.",
+		since:      "4.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteSwapDB(args)
+		},
+	}
+	DBSize = RespCommand{
+		arity:      1,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		summary:    "Returns the number of keys in the database.",
+		since:      "1.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ToRespInteger(client.Database().Len()), nil
+		},
+	}
+	FlushDB = RespCommand{
+		Type:       WRITE,
+		arity:      -1,
+		flags:      []string{"write"},
+		categories: []string{"@keyspace", "@write", "@slow", "@dangerous"},
+		summary:    "Remove all keys from the current database.",
+		since:      "1.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteFlush(args, client, false)
+		},
+	}
+	FlushAll = RespCommand{
+		Type:       WRITE,
+		arity:      -1,
+		flags:      []string{"write"},
+		categories: []string{"@keyspace", "@write", "@slow", "@dangerous"},
+		summary:    "Removes all keys from all databases.",
+		since:      "1.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteFlush(args, client, true)
+		},
+	}
+	Del = RespCommand{
+		Type:       WRITE,
+		arity:      -2,
+		flags:      []string{"write"},
+		categories: []string{"@keyspace", "@write", "@slow"},
+		firstKey:   1,
+		lastKey:    -1,
+		keyStep:    1,
+		summary:    "Deletes one or more keys.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteDel(args, client)
+		},
+	}
+	Unlink = RespCommand{
+		Type:       WRITE,
+		arity:      -2,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    -1,
+		keyStep:    1,
+		summary:    "Asynchronously deletes one or more keys.",
+		since:      "4.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteDel(args, client)
+		},
+	}
+	Exists = RespCommand{
+		arity:      -2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    -1,
+		keyStep:    1,
+		summary:    "Determines whether one or more keys exist.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExists(args, client)
+		},
+	}
+	Rename = RespCommand{
+		Type:       WRITE,
+		arity:      3,
+		flags:      []string{"write"},
+		categories: []string{"@keyspace", "@write", "@slow"},
+		firstKey:   1,
+		lastKey:    2,
+		keyStep:    1,
+		summary:    "Renames a key and overwrites the destination.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteRename(args, client, false)
+		},
+	}
+	RenameNX = RespCommand{
+		Type:       WRITE,
+		arity:      3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    2,
+		keyStep:    1,
+		summary:    "Renames a key only when the target key name doesn't exist.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteRename(args, client, true)
+		},
+	}
+	Copy = RespCommand{
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "denyoom"},
+		categories: []string{"@keyspace", "@write", "@slow"},
+		firstKey:   1,
+		lastKey:    2,
+		keyStep:    1,
+		summary:    "Copies the value of a key to a new key.",
+		since:      "6.2.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteCopy(args, client)
+		},
+	}
+	Touch = RespCommand{
+		arity:      -2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    -1,
+		keyStep:    1,
+		summary:    "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+		since:      "3.2.1",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExists(args, client)
+		},
+	}
+	RandomKey = RespCommand{
+		arity:      1,
+		flags:      []string{"readonly"},
+		categories: []string{"@keyspace", "@read", "@slow"},
+		summary:    "Returns a random key name from the database.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteRandomKey(client)
+		},
+	}
+	Expire = RespCommand{
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Sets the expiration time of a key in seconds.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExpire(EXPIRE, args, client, time.Second, false)
+		},
+	}
+	PExpire = RespCommand{
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Sets the expiration time of a key in milliseconds.",
+		since:      "2.6.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExpire(PEXPIRE, args, client, time.Millisecond, false)
+		},
+	}
+	ExpireAt = RespCommand{
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Sets the expiration time of a key to a Unix timestamp.",
+		since:      "1.2.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExpire(EXPIREAT, args, client, time.Second, true)
+		},
+	}
+	PExpireAt = RespCommand{
+		Type:       WRITE,
+		arity:      -3,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		since:      "2.6.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteExpire(PEXPIREAT, args, client, time.Millisecond, true)
+		},
+	}
+	Ttl = RespCommand{
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the expiration time in seconds of a key.",
+		since:      "1.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteTTL(args, client, time.Second, false)
+		},
+	}
+	PTtl = RespCommand{
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the expiration time in milliseconds of a key.",
+		since:      "2.6.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteTTL(args, client, time.Millisecond, false)
+		},
+	}
+	ExpireTime = RespCommand{
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the expiration time of a key as a Unix timestamp.",
+		since:      "7.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteTTL(args, client, time.Second, true)
+		},
+	}
+	PExpireTime = RespCommand{
+		arity:      2,
+		flags:      []string{"readonly", "fast"},
+		categories: []string{"@keyspace", "@read", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+		since:      "7.0.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteTTL(args, client, time.Millisecond, true)
+		},
+	}
+	Persist = RespCommand{
+		Type:       WRITE,
+		arity:      2,
+		flags:      []string{"write", "fast"},
+		categories: []string{"@keyspace", "@write", "@fast"},
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		summary:    "Removes the expiration time of a key.",
+		since:      "2.2.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecutePersist(args, client)
+		},
+	}
+	Scan = RespCommand{
+		arity:      -2,
+		flags:      []string{"readonly"},
+		categories: []string{"@keyspace", "@read", "@slow"},
+		summary:    "Iterates over the key names in the database.",
+		since:      "2.8.0",
+		group:      "generic",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteScan(args, client)
+		},
+	}
+	Acl = RespCommand{
+		arity:      -2,
+		flags:      []string{"admin", "noscript", "loading", "stale"},
+		categories: []string{"@admin", "@slow", "@dangerous"},
+		summary:    "A container for Access List Control commands.",
+		since:      "6.0.0",
+		group:      "server",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
+			return ExecuteACL(args, client)
+		},
+	}
 	Discard = RespCommand{
-		Execute: func(args []string, rs RedisServer) (string, error) {
+		arity:      1,
+		flags:      []string{"noscript", "loading", "stale", "fast"},
+		categories: []string{"@fast", "@transaction"},
+		summary:    "Discards a transaction.",
+		since:      "2.0.0",
+		group:      "transactions",
+		Execute: func(args []string, rs RedisServer, client *Client) (string, error) {
 			return ToRespSimpleString(OK), nil
 		},
 	}
 )
 
 var RespCommands = map[string]RespCommand{
-	PING:     Ping,
-	ECHO:     Echo,
-	GET:      Get,
-	SET:      Set,
-	INFO:     Info,
-	REPLCONF: ReplConf,
-	PSYNC:    Psync,
-	WAIT:     Wait,
-	CONFIG:   Config,
-	KEYS:     Keys,
-	TYPE:     Type,
-	XADD:     XAdd,
-	XRANGE:   XRange,
-	XREAD:    XRead,
-	INCR:     Incr,
-	MULTI:    Multi,
-	EXEC:     Exec,
-	DISCARD:  Discard,
+	PING:        Ping,
+	ECHO:        Echo,
+	GET:         Get,
+	SET:         Set,
+	INFO:        Info,
+	REPLCONF:    ReplConf,
+	PSYNC:       Psync,
+	WAIT:        Wait,
+	CONFIG:      Config,
+	KEYS:        Keys,
+	TYPE:        Type,
+	XADD:        XAdd,
+	XRANGE:      XRange,
+	XREAD:       XRead,
+	INCR:        Incr,
+	MULTI:       Multi,
+	EXEC:        Exec,
+	DISCARD:     Discard,
+	SHUTDOWN:    Shutdown,
+	SLOWLOG:     Slowlog,
+	LATENCY:     Latency,
+	MONITOR:     Monitor,
+	CLIENT:      ClientCommand,
+	AUTH:        Auth,
+	SELECT:      Select,
+	MOVE:        Move,
+	SWAPDB:      SwapDB,
+	DBSIZE:      DBSize,
+	FLUSHDB:     FlushDB,
+	FLUSHALL:    FlushAll,
+	DEL:         Del,
+	UNLINK:      Unlink,
+	EXISTS:      Exists,
+	RENAME:      Rename,
+	RENAMENX:    RenameNX,
+	COPY:        Copy,
+	TOUCH:       Touch,
+	RANDOMKEY:   RandomKey,
+	EXPIRE:      Expire,
+	PEXPIRE:     PExpire,
+	EXPIREAT:    ExpireAt,
+	PEXPIREAT:   PExpireAt,
+	TTL:         Ttl,
+	PTTL:        PTtl,
+	EXPIRETIME:  ExpireTime,
+	PEXPIRETIME: PExpireTime,
+	PERSIST:     Persist,
+	SCAN:        Scan,
 }
 
 var CommandFlags = map[string]string{
@@ -14,6 +14,15 @@ const (
 	GETACK                  = "GETACK"
 	GETACK_FROM_REPLICA_ARG = "*"
 	XREAD_ONLY_NEW          = "$"
+	XREAD_STREAMS           = "STREAMS"
+	COUNT                   = "COUNT"
+	DOCS                    = "DOCS"
+	GETKEYS                 = "GETKEYS"
+	LIST                    = "LIST"
+	FILTERBY                = "FILTERBY"
+	MODULE                  = "MODULE"
+	ACLCAT                  = "ACLCAT"
+	PATTERN                 = "PATTERN"
 )
 
 // RESP protocol constants. Use for interpreted strings, and regex only if characters are not escaped
@@ -23,12 +32,14 @@ const (
 	SIMPLE_STRING                 = "+"
 	BULK_STRING                   = "$"
 	NULL_BULK_STRING              = "$-1\r\n"
+	NULL_ARRAY                    = "*-1\r\n"
 	ARRAY                         = "*"
 	INTEGER                       = ":"
 	INTEGER_POSITIVE              = "+"
 	INTEGER_NEGATIVE              = "-"
 	EMPTY_KEY_TYPE                = "none"
 	ERROR                         = "-ERR"
+	ERROR_PREFIX                  = "-"
 	QUEUED                        = "QUEUED"
 )
 
@@ -62,11 +73,6 @@ const (
 	RDB_FILENAME_ARG     = "dbfilename"
 )
 
-var RDB_CONFIG = map[string]string{
-	RDB_DIR_ARG:      RDB_DEFAULT_DIR,
-	RDB_FILENAME_ARG: RDB_DEFAULT_FILENAME,
-}
-
 const (
 	RDB_MAGIC_STRING        = "REDIS0007"
 	RDB_METADATA_START      = "FA"
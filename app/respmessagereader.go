@@ -38,10 +38,8 @@ func (r *RESPMessageReader) Read(message string) (bool, error) {
 		}
 
 		// set command or read bulk string of length = nextBytes
+		// unknown commands are read whole, the server replies to them like to any other command
 		if r.command == "" {
-			if !IsRESPCommandSupported(strings.ToUpper(trimmedMessage)) {
-				return true, errors.New("invalid or unsupported resp command: " + trimmedMessage)
-			}
 			r.setCommand(trimmedMessage)
 			// r.setLengthLimit(RespCommands[r.command].argLen)
 		} else {
@@ -97,7 +95,7 @@ func (r *RESPMessageReader) Read(message string) (bool, error) {
 	return true, errors.New("invalid or unsupported command in plain string: " + command)
 }
 
-// Sets the underlying RESP command, making it uppercase. Command must be validated beforehand.
+// Sets the underlying RESP command, making it uppercase
 func (r *RESPMessageReader) setCommand(command string) {
 	r.command = strings.ToUpper(command)
 }
@@ -120,10 +120,6 @@ func StreamItemsToRespArray(s []Stream) string {
 func ToRespInteger(i int) string {
 	intString := strconv.Itoa(i)
 
-	if strings.HasPrefix(intString, INTEGER_NEGATIVE) {
-		return INTEGER + INTEGER_POSITIVE + intString + PROTOCOL_TERMINATOR
-	}
-
 	return INTEGER + intString + PROTOCOL_TERMINATOR
 }
 
@@ -131,6 +127,11 @@ func ToRespError(err error) string {
 	return ERROR + " " + err.Error() + PROTOCOL_TERMINATOR
 }
 
+// ToRespErrorWithCode builds an error reply with a code other than ERR, such as NOAUTH
+func ToRespErrorWithCode(code string, err error) string {
+	return ERROR_PREFIX + code + " " + err.Error() + PROTOCOL_TERMINATOR
+}
+
 func BuildPsyncResponse(masterId string) string {
 	return SIMPLE_STRING + FULLRESYNC + " " + masterId + " " + "0" + PROTOCOL_TERMINATOR
 }
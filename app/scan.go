@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SCAN options
+const (
+	SCAN_MATCH = "MATCH"
+	SCAN_COUNT = "COUNT"
+	SCAN_TYPE  = "TYPE"
+	// number of keys visited when COUNT is not given
+	SCAN_DEFAULT_COUNT = 10
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type scanOptions struct {
+	pattern string
+	count   int
+	// type of the keys to return, any type when empty
+	valueType string
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] [TYPE type]
+func parseScanOptions(args []string) (scanOptions, error) {
+	options := scanOptions{count: SCAN_DEFAULT_COUNT}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if i+1 >= len(args) {
+			return options, errors.New("syntax error")
+		}
+		i++
+		switch option {
+		case SCAN_MATCH:
+			options.pattern = args[i]
+		case SCAN_COUNT:
+			count, err := strconv.Atoi(args[i])
+			if err != nil {
+				return options, ErrNotInteger
+			}
+			if count < 1 {
+				return options, errors.New("syntax error")
+			}
+			options.count = count
+		case SCAN_TYPE:
+			options.valueType = args[i]
+		default:
+			return options, errors.New("syntax error")
+		}
+	}
+	return options, nil
+}
+
+func parseScanCursor(arg string) (uint64, error) {
+	cursor, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ExecuteScan runs SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]. COUNT is a hint: the keyspace
+// is scanned a bucket of keys at a time, so a call returns every key of the buckets it visited. Expired
+// keys are skipped, like keys which do not match the pattern or the type.
+func ExecuteScan(args []string, client *Client) (string, error) {
+	cursor, err := parseScanCursor(args[0])
+	if err != nil {
+		return ToRespError(err), nil
+	}
+	options, err := parseScanOptions(args[1:])
+	if err != nil {
+		return ToRespError(err), nil
+	}
+
+	next, keys := client.Database().Scan(cursor, options.count, func(key string, item MemoryItem) bool {
+		if item.Expired() {
+			return false
+		}
+		if options.valueType != "" && !strings.EqualFold(item.TypeName(), options.valueType) {
+			return false
+		}
+		return options.pattern == "" || GlobMatch(options.pattern, key, false)
+	})
+	return scanReply(next, keys), nil
+}
+
+// scanReply replies the cursor to resume from and the keys or elements returned
+func scanReply(cursor uint64, elements []string) string {
+	return ConcatIntoRespArray([]string{
+		ToRespBulkString(strconv.FormatUint(cursor, 10)),
+		ToRespBulkStringArray(elements...),
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient speaks RESP to a server started by startTestServer
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestClient(t *testing.T, address string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn, bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply, with bulk strings and arrays flattened into their
+// elements joined by spaces
+func (c *testClient) do(args ...string) (string, error) {
+	if _, err := c.conn.Write([]byte(ToRespBulkStringArray(args...))); err != nil {
+		return "", err
+	}
+	return c.readReply()
+}
+
+func (c *testClient) readReply() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, PROTOCOL_TERMINATOR)
+	switch line[:1] {
+	case BULK_STRING:
+		length, _ := strconv.Atoi(line[1:])
+		if length < 0 {
+			return "(nil)", nil
+		}
+		data := make([]byte, length+len(PROTOCOL_TERMINATOR))
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return "", err
+		}
+		return string(data[:length]), nil
+	case ARRAY:
+		count, _ := strconv.Atoi(line[1:])
+		elements := []string{}
+		for i := 0; i < count; i++ {
+			element, err := c.readReply()
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, element)
+		}
+		return strings.Join(elements, " "), nil
+	default:
+		return line, nil
+	}
+}
+
+// startTestServer serves connections to a master on a random local port, returning its address.
+// The databases are flushed, so every test starts from an empty keyspace.
+func startTestServer(t *testing.T) (*RedisMasterServer, string) {
+	t.Helper()
+	ServerDatabases.FlushAll()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveTestConnections(t, listener), listener.Addr().String()
+}
+
+// serveTestConnections handles the connections accepted by the listener until the test ends
+func serveTestConnections(t *testing.T, listener net.Listener) *RedisMasterServer {
+	t.Helper()
+	t.Cleanup(func() { listener.Close() })
+
+	server := NewMasterServer(0)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go HandleConnection(conn, server)
+		}
+	}()
+	return server
+}
+
+func TestConcurrentClients(t *testing.T) {
+	_, address := startTestServer(t)
+	clients := make([]*testClient, stressGoroutines)
+	for i := range clients {
+		clients[i] = dialTestClient(t, address)
+	}
+
+	runConcurrently(func(worker int) {
+		client := clients[worker]
+		key := fmt.Sprintf("stress:%d", worker)
+		for i := 0; i < stressIterations; i++ {
+			value := strconv.Itoa(i)
+			for _, command := range [][]string{
+				{SET, key, value},
+				{INCR, "stress:counter"},
+				{XADD, "stress:stream", "*", "worker", strconv.Itoa(worker)},
+			} {
+				if reply, err := client.do(command...); err != nil || strings.HasPrefix(reply, ERROR_PREFIX) {
+					t.Errorf("%s failed: %q %v", command[0], reply, err)
+					return
+				}
+			}
+			if reply, err := client.do(GET, key); err != nil || reply != value {
+				t.Errorf("GET %s returned %q %v, want %q", key, reply, err, value)
+				return
+			}
+		}
+	})
+
+	want := strconv.Itoa(stressGoroutines * stressIterations)
+	if reply, err := clients[0].do(GET, "stress:counter"); err != nil || reply != want {
+		t.Fatalf("counter is %q %v, want %s", reply, err, want)
+	}
+	stream, err := ServerDatabases.DB(0).LookupStream("stress:stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream) != stressGoroutines*stressIterations {
+		t.Fatalf("stream has %d entries, want %d", len(stream), stressGoroutines*stressIterations)
+	}
+}
+
+func TestXAddKeepsExpiry(t *testing.T) {
+	_, address := startTestServer(t)
+	client := dialTestClient(t, address)
+
+	for _, command := range [][]string{
+		{XADD, "s", "*", "f", "v"},
+		{EXPIRE, "s", "100"},
+		{XADD, "s", "*", "f", "v"},
+	} {
+		if reply, err := client.do(command...); err != nil || strings.HasPrefix(reply, ERROR_PREFIX) {
+			t.Fatalf("%s failed: %q %v", command[0], reply, err)
+		}
+	}
+	if reply, err := client.do(TTL, "s"); err != nil || reply != ":100" {
+		t.Fatalf("TTL returned %q %v, want :100", reply, err)
+	}
+}
+
+func TestExecAbortsAfterRejectedCommand(t *testing.T) {
+	_, address := startTestServer(t)
+	client := dialTestClient(t, address)
+
+	for _, step := range []struct {
+		command []string
+		reply   string
+	}{
+		{[]string{MULTI}, "+OK"},
+		{[]string{SET, "a", "1"}, "+QUEUED"},
+		{[]string{GET}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"NOSUCHCOMMAND", "x"}, "-ERR unknown command 'nosuchcommand', with args beginning with: 'x' "},
+		{[]string{EXEC}, "-EXECABORT Transaction discarded because of previous errors."},
+		{[]string{GET, "a"}, "(nil)"},
+		// the next transaction starts clean
+		{[]string{MULTI}, "+OK"},
+		{[]string{SET, "a", "1"}, "+QUEUED"},
+		{[]string{EXEC}, "+OK"},
+	} {
+		if reply, err := client.do(step.command...); err != nil || reply != step.reply {
+			t.Fatalf("%s returned %q %v, want %q", step.command[0], reply, err, step.reply)
+		}
+	}
+}
+
+func TestXReadBlockWakesOnXAdd(t *testing.T) {
+	_, address := startTestServer(t)
+	reader := dialTestClient(t, address)
+	writer := dialTestClient(t, address)
+	// a write stuck on a waiter which is gone fails the test instead of hanging it
+	writer.conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if reply, err := writer.do(XADD, "s", "1-1", "f", "v"); err != nil || reply != "1-1" {
+		t.Fatalf("XADD returned %q %v", reply, err)
+	}
+	for _, newId := range []string{"1-2", "1-3"} {
+		blocked := Stats.BlockedClients.Load()
+		replies := make(chan string, 1)
+		go func() {
+			reply, err := reader.do(XREAD, "block", "0", "streams", "s", "$")
+			if err != nil {
+				reply = err.Error()
+			}
+			replies <- reply
+		}()
+		for Stats.BlockedClients.Load() == blocked {
+			time.Sleep(time.Millisecond)
+		}
+
+		if reply, err := writer.do(XADD, "s", newId, "f", "v"); err != nil || reply != newId {
+			t.Fatalf("XADD returned %q %v", reply, err)
+		}
+		select {
+		case reply := <-replies:
+			if !strings.Contains(reply, newId) {
+				t.Fatalf("XREAD returned %q, want the entry %s", reply, newId)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("XREAD was not woken up by XADD")
+		}
+	}
+
+	// nobody waits any more, the next entry is added right away
+	if reply, err := writer.do(XADD, "s", "1-4", "f", "v"); err != nil || reply != "1-4" {
+		t.Fatalf("XADD returned %q %v", reply, err)
+	}
+}
+
+func TestAppendOnlyFileFollowsWriteOrder(t *testing.T) {
+	server, address := startTestServer(t)
+	setTestConfig(t, map[string]string{CONFIG_DIR: t.TempDir()})
+	if err := StartAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(StopAppendOnly)
+	clients := make([]*testClient, stressGoroutines)
+	for i := range clients {
+		clients[i] = dialTestClient(t, address)
+	}
+
+	// the entries of the stream are in the order they were added, which the file must follow to
+	// rebuild it
+	runConcurrently(func(worker int) {
+		for i := 0; i < stressIterations; i++ {
+			if reply, err := clients[worker].do(XADD, "order", "*", "entry", fmt.Sprintf("w%di%d", worker, i)); err != nil || strings.HasPrefix(reply, ERROR_PREFIX) {
+				t.Errorf("XADD returned %q %v", reply, err)
+				return
+			}
+		}
+	})
+
+	want, err := ServerDatabases.DB(0).LookupStream("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ServerDatabases.FlushAll()
+	if err := replayAppendOnlyFile(GetAppendOnlyFilePath(), server); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ServerDatabases.DB(0).LookupStream("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("the stream has %d entries after loading the append only file, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].values["entry"] != want[i].values["entry"] {
+			t.Fatalf("entry %d is %v after loading the append only file, want %v", i, got[i].values["entry"], want[i].values["entry"])
+		}
+	}
+}
+
+func TestPsyncSendsSnapshot(t *testing.T) {
+	_, address := startTestServer(t)
+	client := dialTestClient(t, address)
+	for _, command := range [][]string{
+		{SET, "a", "1"},
+		{SET, "b", "2", "PX", "100000"},
+		{XADD, "s", "1-1", "f", "v"},
+		{SELECT, "1"},
+		{SET, "c", "3"},
+	} {
+		if reply, err := client.do(command...); err != nil || strings.HasPrefix(reply, ERROR_PREFIX) {
+			t.Fatalf("%s failed: %q %v", command[0], reply, err)
+		}
+	}
+
+	replica := dialTestClient(t, address)
+	replica.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if reply, err := replica.do(PSYNC, "?", "-1"); err != nil || !strings.HasPrefix(reply, SIMPLE_STRING+FULLRESYNC) {
+		t.Fatalf("PSYNC returned %q %v", reply, err)
+	}
+	lengthLine, err := replica.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(lengthLine, BULK_STRING), PROTOCOL_TERMINATOR))
+	if err != nil {
+		t.Fatalf("bad RDB length %q", lengthLine)
+	}
+	rdb := make([]byte, length)
+	if _, err := io.ReadFull(replica.reader, rdb); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readRDBEntries(bufio.NewReader(bytes.NewReader(rdb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]RDBTableEntry{}
+	for _, entry := range entries {
+		got[entry.key] = entry
+	}
+	for key, want := range map[string]struct {
+		value    string
+		db       int
+		expiring bool
+	}{
+		"a": {"1", 0, false},
+		"b": {"2", 0, true},
+		"c": {"3", 1, false},
+	} {
+		entry, exists := got[key]
+		if !exists || entry.value != want.value || entry.db != want.db || (entry.expiry != 0) != want.expiring {
+			t.Errorf("snapshot holds %s as %+v, want %+v", key, entry, want)
+		}
+	}
+
+	// the stream, which the RDB file cannot hold, follows as commands, then the writes run after PSYNC
+	if reply, err := client.do(SET, "d", "4"); err != nil || reply != "+OK" {
+		t.Fatalf("SET returned %q %v", reply, err)
+	}
+	for _, want := range []string{"SELECT 0", "XADD s 1-1 f v", "SELECT 1", "SET d 4"} {
+		if reply, err := replica.readReply(); err != nil || reply != want {
+			t.Fatalf("replica received %q %v, want %q", reply, err, want)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"time"
 )
 
 type BytesReadable interface {
@@ -17,12 +19,49 @@ func HandleConnection(conn net.Conn, server RedisServer) {
 
 	defer conn.Close()
 
-	var trx Transaction
+	lifecycle := server.GetStatus().Lifecycle
+	if !lifecycle.TrackConnection(conn) {
+		return
+	}
+	defer lifecycle.UntrackConnection(conn)
+	defer ServerMonitors.Remove(conn)
+
+	client := ServerClients.Register(conn, CLIENT_TYPE_NORMAL)
+	defer ServerClients.Unregister(client)
+	if ServerClients.Len() > ServerConfig.GetInt(CONFIG_MAXCLIENTS) {
+		Stats.RejectedConnections.Add(1)
+		conn.Write([]byte(ToRespError(ErrMaxClients)))
+		return
+	}
+	if deniedByProtectedMode(client) {
+		conn.Write([]byte(ToRespErrorWithCode(DENIED, ErrProtectedMode)))
+		return
+	}
+	Stats.TotalConnectionsReceived.Add(1)
+	client.requireAuth()
 	reader := bufio.NewReader(conn)
 	respReader := NewRESPMessageReader()
+	// the part of a line read before the read deadline expired
+	partial := ""
 
 	for {
-		message, err := reader.ReadString('\n')
+		// reads wake up periodically to check the idle timeout and the output buffer limit
+		conn.SetReadDeadline(time.Now().Add(CLIENT_CRON_INTERVAL))
+		line, err := reader.ReadString('\n')
+		partial += line
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if client.idleTimedOut() {
+				fmt.Println("Closing idle client: ", conn.RemoteAddr())
+				return
+			}
+			if client.outputLimitReached(client.outputPending.Load()) {
+				client.closeForOutputLimit()
+				return
+			}
+			continue
+		}
+		message := partial
+		partial = ""
 		if err != nil {
 			if err == io.EOF {
 				fmt.Println("Connection closed by client")
@@ -33,6 +72,7 @@ func HandleConnection(conn net.Conn, server RedisServer) {
 			return
 		}
 
+		client.queryBuffer.Store(int64(reader.Buffered()))
 		ready, err := respReader.Read(message)
 		if err != nil {
 			fmt.Println("RESP Processor read error: ", err)
@@ -49,7 +89,18 @@ func HandleConnection(conn net.Conn, server RedisServer) {
 
 		if ready {
 			commandComponents := respReader.GetCommandComponents()
-			err := server.RunCommand(commandComponents, conn, &trx)
+			if isPausable(client, commandComponents) {
+				ServerClients.WaitIfPaused(RespCommands[commandComponents.Command].Type == WRITE)
+			}
+			// replication traffic is never paused, since a shutdown may be waiting on it
+			gated := commandComponents.Command != REPLCONF
+			if gated && !lifecycle.BeginCommand() {
+				return
+			}
+			err := DispatchCommand(server, commandComponents, client)
+			if gated {
+				lifecycle.EndCommand()
+			}
 			if err != nil {
 				fmt.Printf("Error executing command %s in %s. Error: %s\n", commandComponents.Command, server.ReplicaInfo().role, err.Error())
 			}
@@ -69,6 +120,9 @@ func HandleHandshakeConnection(conn net.Conn, server RedisServer, reader *bufio.
 	if !ok {
 		return errors.New("cannot handle handshake connection from a non-slave server")
 	}
+	client := ServerClients.Register(conn, CLIENT_TYPE_MASTER)
+	defer ServerClients.Unregister(client)
+	slaveServer.masterClient = client
 
 	for {
 		message, err := reader.ReadString('\n')
@@ -90,7 +144,10 @@ func HandleHandshakeConnection(conn net.Conn, server RedisServer, reader *bufio.
 
 		if ready {
 			commandComponents := respReader.GetCommandComponents()
-			err := slaveServer.RunCommandSilently(commandComponents)
+			var err error
+			RunOnExecutor(func() {
+				err = slaveServer.RunCommandSilently(commandComponents)
+			})
 			if err != nil {
 				fmt.Printf("Error executing command %s in %s. Error: %s\n", commandComponents.Command, server.ReplicaInfo().role, err.Error())
 			}
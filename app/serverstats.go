@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStartTime is used to report the uptime of the server
+var serverStartTime = time.Now()
+
+// ServerStats holds the counters reported by INFO and cleared by CONFIG RESETSTAT
+type ServerStats struct {
+	TotalCommandsProcessed   atomic.Int64
+	TotalConnectionsReceived atomic.Int64
+	KeyspaceHits             atomic.Int64
+	KeyspaceMisses           atomic.Int64
+	ExpiredKeys              atomic.Int64
+	// active expire cycles which stopped at their time limit
+	ExpiredTimeCapReached atomic.Int64
+	BlockedClients        atomic.Int64
+	// connections refused because of maxclients
+	RejectedConnections             atomic.Int64
+	ClientOutputLimitDisconnections atomic.Int64
+	// number of writes since the last successful RDB save, not cleared by CONFIG RESETSTAT
+	Dirty              atomic.Int64
+	LastSaveTime       atomic.Int64
+	LastSaveFailed     atomic.Bool
+	AofLastWriteFailed atomic.Bool
+	UsedMemoryPeak     atomic.Uint64
+	// bits of the float64 estimating the share of keys with an expiry which already expired
+	expiredStalePerc atomic.Uint64
+	commandsMu       sync.Mutex
+	commands         map[string]*CommandStats
+}
+
+// CommandStats holds the counters of a single command, reported by INFO commandstats and INFO latencystats
+type CommandStats struct {
+	Calls int64
+	Usec  int64
+	// calls refused before running, such as calls with a wrong number of arguments
+	RejectedCalls int64
+	// calls which ran and replied with an error
+	FailedCalls int64
+	Latency     *LatencyHistogram
+}
+
+var Stats = NewServerStats()
+
+func NewServerStats() *ServerStats {
+	s := &ServerStats{commands: map[string]*CommandStats{}}
+	s.LastSaveTime.Store(serverStartTime.Unix())
+	return s
+}
+
+// Reset clears the counters, keeping the ones describing the state of the server rather than its history
+func (s *ServerStats) Reset() {
+	s.TotalCommandsProcessed.Store(0)
+	s.TotalConnectionsReceived.Store(0)
+	s.KeyspaceHits.Store(0)
+	s.KeyspaceMisses.Store(0)
+	s.ExpiredKeys.Store(0)
+	s.ExpiredTimeCapReached.Store(0)
+	s.expiredStalePerc.Store(0)
+	s.RejectedConnections.Store(0)
+	s.ClientOutputLimitDisconnections.Store(0)
+	s.UsedMemoryPeak.Store(0)
+
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	s.commands = map[string]*CommandStats{}
+}
+
+// RecordExpireCycle updates the stale keys estimate with an active expire cycle which found expired
+// keys among sampled ones. Like Redis, the estimate is a moving average favoring past cycles.
+func (s *ServerStats) RecordExpireCycle(sampled, expired int, timeCapReached bool) {
+	if timeCapReached {
+		s.ExpiredTimeCapReached.Add(1)
+	}
+	current := 0.0
+	if sampled > 0 {
+		current = float64(expired) / float64(sampled)
+	}
+	previous := math.Float64frombits(s.expiredStalePerc.Load())
+	s.expiredStalePerc.Store(math.Float64bits(current*0.05 + previous*0.95))
+}
+
+// ExpiredStalePerc returns the estimated percentage of keys with an expiry which already expired
+func (s *ServerStats) ExpiredStalePerc() float64 {
+	return math.Float64frombits(s.expiredStalePerc.Load()) * 100
+}
+
+// RecordCommand counts a call to command which ran for duration
+func (s *ServerStats) RecordCommand(command string, duration time.Duration, failed bool) {
+	s.TotalCommandsProcessed.Add(1)
+	trackLatency := ServerConfig.Get(CONFIG_LATENCY_TRACKING) == CONFIG_YES
+
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	stats := s.commandStats(command)
+	stats.Calls++
+	stats.Usec += duration.Microseconds()
+	if failed {
+		stats.FailedCalls++
+	}
+	if trackLatency {
+		if stats.Latency == nil {
+			stats.Latency = &LatencyHistogram{}
+		}
+		stats.Latency.Record(duration.Microseconds())
+	}
+}
+
+// RecordRejectedCommand counts a call to command which was refused before running
+func (s *ServerStats) RecordRejectedCommand(command string) {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	s.commandStats(command).RejectedCalls++
+}
+
+func (s *ServerStats) commandStats(command string) *CommandStats {
+	name := strings.ToLower(command)
+	stats, exists := s.commands[name]
+	if !exists {
+		stats = &CommandStats{}
+		s.commands[name] = stats
+	}
+	return stats
+}
+
+// CommandStats returns a copy of the counters of every command called since the last reset,
+// sorted by command name. Histograms are copied as well, so they can be read without holding a lock.
+func (s *ServerStats) CommandStats() ([]string, []CommandStats) {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	names := make([]string, 0, len(s.commands))
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	stats := make([]CommandStats, 0, len(names))
+	for _, name := range names {
+		commandStats := *s.commands[name]
+		if commandStats.Latency != nil {
+			latency := *commandStats.Latency
+			commandStats.Latency = &latency
+		}
+		stats = append(stats, commandStats)
+	}
+	return names, stats
+}
+
+// RecordSave updates the persistence counters after an RDB save
+func (s *ServerStats) RecordSave(err error) {
+	s.LastSaveFailed.Store(err != nil)
+	if err == nil {
+		s.Dirty.Store(0)
+		s.LastSaveTime.Store(time.Now().Unix())
+	}
+}
+
+// RecordKeyspaceLookup counts a key lookup by a read command as a hit or a miss
+func (s *ServerStats) RecordKeyspaceLookup(hit bool) {
+	if hit {
+		s.KeyspaceHits.Add(1)
+	} else {
+		s.KeyspaceMisses.Add(1)
+	}
+}
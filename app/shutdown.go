@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SHUTDOWN arguments
+const (
+	SHUTDOWN_NOSAVE = "NOSAVE"
+	SHUTDOWN_SAVE   = "SAVE"
+	SHUTDOWN_NOW    = "NOW"
+	SHUTDOWN_FORCE  = "FORCE"
+)
+
+const (
+	// maximum time spent waiting for in-flight commands and for replicas to catch up
+	SHUTDOWN_TIMEOUT       = 10 * time.Second
+	SHUTDOWN_POLL_INTERVAL = 10 * time.Millisecond
+)
+
+var (
+	ErrShutdownInProgress = errors.New("shutdown already in progress")
+	ErrShutdownFailed     = errors.New("Errors trying to SHUTDOWN. Check logs.")
+)
+
+type ShutdownOptions struct {
+	Save   bool
+	NoSave bool
+	// skip waiting for in-flight commands and replicas
+	Now bool
+	// shut down even if the RDB file cannot be saved
+	Force bool
+	// set when a client runs SHUTDOWN, since that client's own command is still in flight
+	fromClient bool
+}
+
+func ParseShutdownOptions(args []string) (ShutdownOptions, error) {
+	options := ShutdownOptions{}
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case SHUTDOWN_NOSAVE:
+			options.NoSave = true
+		case SHUTDOWN_SAVE:
+			options.Save = true
+		case SHUTDOWN_NOW:
+			options.Now = true
+		case SHUTDOWN_FORCE:
+			options.Force = true
+		default:
+			return options, errors.New("syntax error")
+		}
+	}
+	if options.Save && options.NoSave {
+		return options, errors.New("syntax error")
+	}
+	return options, nil
+}
+
+// ServerLifecycle tracks the connections and in-flight commands of a server, and coordinates its
+// shutdown with the accept loop.
+type ServerLifecycle struct {
+	mu           sync.Mutex
+	conns        map[net.Conn]struct{}
+	inFlight     int
+	shuttingDown bool
+	// closed when the current shutdown attempt either completes or is aborted
+	shutdownDone chan struct{}
+	completed    bool
+}
+
+func NewServerLifecycle() *ServerLifecycle {
+	return &ServerLifecycle{conns: map[net.Conn]struct{}{}}
+}
+
+// TrackConnection registers a new connection. It returns false if the server is shutting down,
+// in which case the connection must be closed.
+func (l *ServerLifecycle) TrackConnection(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.completed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	return true
+}
+
+// ConnectionCount returns the number of open client connections
+func (l *ServerLifecycle) ConnectionCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.conns)
+}
+
+func (l *ServerLifecycle) UntrackConnection(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+}
+
+// BeginCommand must be called before running a command. While a shutdown is in progress it blocks
+// until the shutdown completes or is aborted, and returns false if the command must not run.
+func (l *ServerLifecycle) BeginCommand() bool {
+	l.mu.Lock()
+	for l.shuttingDown {
+		done := l.shutdownDone
+		l.mu.Unlock()
+		<-done
+		l.mu.Lock()
+	}
+	defer l.mu.Unlock()
+	if l.completed {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+func (l *ServerLifecycle) EndCommand() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+func (l *ServerLifecycle) ShuttingDown() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.shuttingDown || l.completed
+}
+
+func (l *ServerLifecycle) beginShutdown() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shuttingDown || l.completed {
+		return false
+	}
+	l.shuttingDown = true
+	l.shutdownDone = make(chan struct{})
+	return true
+}
+
+func (l *ServerLifecycle) endShutdown(completed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shuttingDown = false
+	l.completed = completed
+	close(l.shutdownDone)
+}
+
+// waitForCommands waits until at most limit commands are in flight, or until the timeout expires
+func (l *ServerLifecycle) waitForCommands(limit int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		inFlight := l.inFlight
+		l.mu.Unlock()
+		if inFlight <= limit {
+			return
+		}
+		time.Sleep(SHUTDOWN_POLL_INTERVAL)
+	}
+	fmt.Println("Timed out waiting for in-flight commands to finish")
+}
+
+func (l *ServerLifecycle) closeConnections() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+// AcceptConnections accepts connections until the listener fails. When the failure is caused by a
+// shutdown it waits for the shutdown to finish, returning nil if it completed, or listening again
+// with listen if it was aborted.
+func (l *ServerLifecycle) AcceptConnections(listener net.Listener, listen func() (net.Listener, error), handle func(net.Conn)) error {
+	for {
+		conn, err := listener.Accept()
+		if err == nil {
+			go handle(conn)
+			continue
+		}
+
+		l.mu.Lock()
+		shuttingDown, done := l.shuttingDown, l.shutdownDone
+		l.mu.Unlock()
+		if !shuttingDown {
+			if l.ShuttingDown() {
+				return nil
+			}
+			return err
+		}
+
+		<-done
+		if l.ShuttingDown() {
+			return nil
+		}
+		listener, err = listen()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ShutdownServer runs the steps shared by every server type: stop accepting connections, let
+// in-flight commands finish, run waitForReplicas, save the RDB file when requested, flush and close
+// the append only file and close every client connection. If saving fails the shutdown is aborted,
+// unless it is forced.
+func ShutdownServer(s RedisServer, options ShutdownOptions, stopListening func(), waitForReplicas func(time.Duration)) error {
+	lifecycle := s.GetStatus().Lifecycle
+	if !lifecycle.beginShutdown() {
+		return ErrShutdownInProgress
+	}
+
+	fmt.Println("User requested shutdown...")
+	stopListening()
+
+	if !options.Now {
+		inFlightLimit := 0
+		if options.fromClient {
+			inFlightLimit = 1
+		}
+		lifecycle.waitForCommands(inFlightLimit, SHUTDOWN_TIMEOUT)
+		waitForReplicas(SHUTDOWN_TIMEOUT)
+	}
+
+	// without an explicit SAVE or NOSAVE, the RDB file is saved only if save points are configured
+	save := options.Save || (!options.NoSave && ServerConfig.Get(CONFIG_SAVE) != "")
+	if save {
+		fmt.Println("Saving the final RDB snapshot before exiting.")
+		err := SaveRDBFile(GetRDBFilePath())
+		if err != nil {
+			fmt.Println("Error trying to save the DB, can't exit: ", err)
+			if !options.Force {
+				lifecycle.endShutdown(false)
+				return ErrShutdownFailed
+			}
+		}
+	}
+
+	// the writes acknowledged since the last fsync reach the disk before exiting
+	StopAppendOnly()
+	lifecycle.closeConnections()
+	lifecycle.endShutdown(true)
+	fmt.Println("Redis is now ready to exit, bye bye...")
+	return nil
+}
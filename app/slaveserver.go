@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ReplicaInfo struct {
@@ -23,36 +27,47 @@ type ReplicaInfo struct {
 }
 
 type RedisSlaveServer struct {
-	Role             string
-	Host             string
-	Port             int
-	MasterPort       int
-	Status           ServerStatus
-	listener         net.Listener
+	Role string
+	// addresses the server listens on
+	Bind       []string
+	Port       int
+	MasterHost string
+	MasterPort int
+	Status     ServerStatus
+	listener   net.Listener
+	// mu guards the listener, which is replaced if a shutdown is aborted, and the replication offset
+	mu               sync.Mutex
 	masterConnection net.Conn
-	replicaInfo      ReplicaInfo
-	rdbFile          []byte
-	offset           int
-	rdbConfig        map[string]string
+	// masterClient runs the commands of the replication stream
+	masterClient *Client
+	replicaInfo  ReplicaInfo
+	offset       int
 }
 
-func NewSlaveServer(port int, replicaOf string) (RedisSlaveServer, error) {
-	MasterPort := DEFAULT_PORT
+func NewSlaveServer(port int, replicaOf string) (*RedisSlaveServer, error) {
+	MasterHost, MasterPort := DEFAULT_HOST_ADDRESS, DEFAULT_PORT
 	replicaOfParts := strings.Split(replicaOf, " ")
+	if replicaOfParts[0] != "" {
+		MasterHost = replicaOfParts[0]
+	}
 
 	if len(replicaOfParts) >= 2 {
 		port, err := strconv.Atoi(replicaOfParts[1])
 		if err != nil {
-			return RedisSlaveServer{}, errors.New("could not create slave. Invalid replicaof argument")
+			return nil, errors.New("could not create slave. Invalid replicaof argument")
 		}
 		MasterPort = port
 	}
 
-	server := RedisSlaveServer{
+	server := &RedisSlaveServer{
 		Role:       SLAVE,
-		Host:       DEFAULT_HOST,
+		Bind:       strings.Fields(ServerConfig.Get(CONFIG_BIND)),
 		Port:       port,
+		MasterHost: MasterHost,
 		MasterPort: MasterPort,
+		Status: ServerStatus{
+			Lifecycle: NewServerLifecycle(),
+		},
 		replicaInfo: ReplicaInfo{
 			role: SLAVE,
 		},
@@ -62,20 +77,20 @@ func NewSlaveServer(port int, replicaOf string) (RedisSlaveServer, error) {
 }
 
 func (r *RedisSlaveServer) Start() error {
-	port := strconv.Itoa(r.Port)
-	listener, err := net.Listen("tcp", r.Host+":"+port)
+	listener, err := r.listen()
 	if err != nil {
 		fmt.Println("Error connecting to master server")
 		return err
 	}
-	r.listener = listener
 
-	conn, err := net.Dial("tcp", DEFAULT_HOST_ADDRESS+":"+strconv.Itoa(r.MasterPort))
+	conn, err := r.dialMaster()
 	if err != nil {
 		fmt.Println("Error connecting to master server")
 		return err
 	}
+	r.mu.Lock()
 	r.masterConnection = conn
+	r.mu.Unlock()
 
 	masterConnReader := bufio.NewReader(conn)
 	handshakeErrChannel := make(chan error)
@@ -87,7 +102,7 @@ func (r *RedisSlaveServer) Start() error {
 		handshakeErrChannel <- err
 	}()
 	go func() {
-		err := r.acceptConnections(r.listener)
+		err := r.acceptConnections(listener)
 		serverConnErrChannel <- err
 	}()
 
@@ -96,8 +111,11 @@ func (r *RedisSlaveServer) Start() error {
 	for {
 		select {
 		case err := <-handshakeErrChannel:
-			if err == io.EOF {
-				fmt.Println("EOFF")
+			if r.Status.Lifecycle.ShuttingDown() {
+				continue
+			}
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				fmt.Println("Connection with master lost")
 				continue
 			}
 			if err != nil {
@@ -107,8 +125,8 @@ func (r *RedisSlaveServer) Start() error {
 		case err := <-serverConnErrChannel:
 			if err != nil {
 				fmt.Println("Error accepting connection: ", err)
-				return err
 			}
+			return err
 		}
 	}
 }
@@ -117,11 +135,68 @@ func (r *RedisSlaveServer) Stop() error {
 	return r.listener.Close()
 }
 
+// dialMaster connects to the master, over TLS when tls-replication is enabled
+func (r *RedisSlaveServer) dialMaster() (net.Conn, error) {
+	address := net.JoinHostPort(r.MasterHost, strconv.Itoa(r.MasterPort))
+	if ServerConfig.Get(CONFIG_TLS_REPLICATION) != CONFIG_YES {
+		return net.Dial("tcp", address)
+	}
+	config, err := ReplicationTLSConfig(r.MasterHost)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", address, config)
+}
+
+func (r *RedisSlaveServer) listen() (net.Listener, error) {
+	listener, err := Listen(r.Bind, r.Port)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.listener = listener
+	r.mu.Unlock()
+	return listener, nil
+}
+
+func (r *RedisSlaveServer) Shutdown(options ShutdownOptions) error {
+	stopListening := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.listener.Close()
+	}
+	// replicas have no replicas of their own to wait for
+	waitForReplicas := func(time.Duration) {}
+
+	err := ShutdownServer(r, options, stopListening, waitForReplicas)
+	if err != nil {
+		return err
+	}
+	return r.masterConnection.Close()
+}
+
 func (r *RedisSlaveServer) ReplicaInfo() ReplicaInfo {
 	return r.replicaInfo
 }
 
-func (r *RedisSlaveServer) runCommandInternally(cmp CommandComponents) (string, bool, error) {
+func (r *RedisSlaveServer) ReplicationInfo() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	linkStatus := "down"
+	if r.masterConnection != nil {
+		linkStatus = "up"
+	}
+	return []string{
+		"role:" + SLAVE,
+		"master_host:" + r.MasterHost,
+		fmt.Sprintf("master_port:%d", r.MasterPort),
+		"master_link_status:" + linkStatus,
+		fmt.Sprintf("slave_repl_offset:%d", r.offset),
+		"connected_slaves:0",
+	}
+}
+
+func (r *RedisSlaveServer) runCommandInternally(cmp CommandComponents, client *Client) (string, bool, error) {
 	var err error
 	var result string
 	var writeToMaster bool
@@ -139,7 +214,10 @@ func (r *RedisSlaveServer) runCommandInternally(cmp CommandComponents) (string,
 			result = ToRespBulkStringArray(REPLCONF, ACK, strconv.Itoa(r.offset))
 		}
 	default:
-		result, err = RespCommands[command].Execute(args, r)
+		result, err = RespCommands[command].Execute(args, r, client)
+	}
+	if RespCommands[command].Type == WRITE {
+		Stats.Dirty.Add(1)
 	}
 
 	if err != nil {
@@ -150,11 +228,14 @@ func (r *RedisSlaveServer) runCommandInternally(cmp CommandComponents) (string,
 }
 
 // Use for commands sent by a client which is NOT master
-func (r RedisSlaveServer) RunCommand(cmp CommandComponents, conn net.Conn, t *Transaction) error {
-	result, _, err := r.runCommandInternally(cmp)
+func (r *RedisSlaveServer) RunCommand(cmp CommandComponents, conn net.Conn, client *Client) error {
+	result, _, err := r.runCommandInternally(cmp, client)
 	if err != nil {
 		return err
 	}
+	if result == "" {
+		return nil
+	}
 	_, err = conn.Write([]byte(result))
 	if err != nil {
 		return err
@@ -165,7 +246,13 @@ func (r RedisSlaveServer) RunCommand(cmp CommandComponents, conn net.Conn, t *Tr
 
 // Use for running commands sent by the master (handshake connection)
 func (r *RedisSlaveServer) RunCommandSilently(cmp CommandComponents) error {
-	result, writeToMaster, err := r.runCommandInternally(cmp)
+	if !IsRESPCommandSupported(cmp.Command) {
+		return unknownCommandError(cmp)
+	}
+	client := r.masterClient
+	client.beginCommand(cmp.Command, cmp.Args)
+	ServerMonitors.Feed(client.DB(), client.Address(), cmp.Command, cmp.Args)
+	result, writeToMaster, err := r.runCommandInternally(cmp, client)
 	if err != nil {
 		return err
 	}
@@ -184,26 +271,23 @@ func (r *RedisSlaveServer) RunCommandSilently(cmp CommandComponents) error {
 	return nil
 }
 
-func (r *RedisSlaveServer) GetRDBConfig() map[string]string {
-	return r.rdbConfig
-}
-
 func (r *RedisSlaveServer) GetStatus() *ServerStatus {
 	return &r.Status
 }
 
+// PropagateExpired does nothing, replicas have no replicas of their own
+func (r *RedisSlaveServer) PropagateExpired(db int, key string) {}
+
 func (r *RedisSlaveServer) acceptConnections(l net.Listener) error {
 	fmt.Println("Slave server listening on port", r.Port)
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return err
-		}
-		go HandleConnection(conn, r)
-	}
+	return r.Status.Lifecycle.AcceptConnections(l, r.listen, func(conn net.Conn) {
+		HandleConnection(conn, r)
+	})
 }
 
 func (r *RedisSlaveServer) updateProcessedBytes(bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.offset += bytes
 	fmt.Println("processed bytes increased by ", bytes, "final: ", r.offset)
 }
@@ -216,18 +300,36 @@ func (r *RedisSlaveServer) handshakeWithMaster(reader *bufio.Reader) error {
 	}
 
 	pingResponseExpected := ToRespSimpleString(PONG)
-	pingResponse, err := BufioRead(reader, pingResponseExpected)
+	pingResponse, err := reader.ReadString('\n')
 	if err != nil {
 		r.masterConnection.Close()
 		fmt.Println("Failed to read response from master server")
 		return err
 	}
-	if pingResponse != pingResponseExpected {
+	// a master requiring a password replies to PING with NOAUTH until the replica authenticates
+	if pingResponse != pingResponseExpected && !isAuthError(pingResponse) {
 		r.masterConnection.Close()
 		return fmt.Errorf("unexpected response to %s from master. Expected: %s Received: %s", PING, pingResponseExpected, pingResponse)
 	}
 
-	// * 2 - REPLCONF
+	// * 2 - AUTH
+	if masterAuth := ServerConfig.Get(CONFIG_MASTERAUTH); masterAuth != "" {
+		_, err = r.masterConnection.Write([]byte(ToRespBulkStringArray(AUTH, masterAuth)))
+		if err != nil {
+			return err
+		}
+		authResponse, err := reader.ReadString('\n')
+		if err != nil {
+			r.masterConnection.Close()
+			return err
+		}
+		if authResponse != ToRespSimpleString(OK) {
+			r.masterConnection.Close()
+			return fmt.Errorf("unable to AUTH to master: %s", strings.TrimSpace(authResponse))
+		}
+	}
+
+	// * 3 - REPLCONF
 	replConfResponseExpected := ToRespSimpleString(OK)
 	replConf1 := REPLCONF + " " + "listening-port" + " " + strconv.Itoa(r.Port)
 	replConf2 := REPLCONF + " " + "capa" + " " + "psync2"
@@ -254,7 +356,7 @@ func (r *RedisSlaveServer) handshakeWithMaster(reader *bufio.Reader) error {
 		}
 	}
 
-	// * 3 - PSYNC
+	// * 4 - PSYNC
 	r.masterConnection.Write([]byte(ToRespBulkStringArray(PSYNC, "?", "-1")))
 	psyncResponseExpected := BuildPsyncResponse(strings.Repeat("*", REPLICA_ID_LENGTH)) // Slaves have no visibility of master IDs on startup.
 	psyncResponse, err := BufioRead(reader, psyncResponseExpected)
@@ -267,7 +369,7 @@ func (r *RedisSlaveServer) handshakeWithMaster(reader *bufio.Reader) error {
 		return fmt.Errorf("unexpected response to %s from master. Expected: %s Received: %s", PSYNC, psyncResponseExpected, psyncResponse)
 	}
 
-	// * 4 - RDB File
+	// * 5 - RDB File
 	fileLengthPrefix, err := reader.ReadString('\n')
 	if err != nil {
 		return err
@@ -278,11 +380,22 @@ func (r *RedisSlaveServer) handshakeWithMaster(reader *bufio.Reader) error {
 		return err
 	}
 
-	rdbFile, err := BufioRead(reader, fileLength)
+	// a full resynchronization replaces the dataset with the snapshot of the master
+	rdbFile := make([]byte, fileLength)
+	_, err = io.ReadFull(reader, rdbFile)
+	if err != nil {
+		return err
+	}
+	entries, err := readRDBEntries(bufio.NewReader(bytes.NewReader(rdbFile)))
+	if err != nil {
+		return err
+	}
+	ServerDatabases.FlushAll()
+	loaded, err := loadRDBEntries(entries)
 	if err != nil {
 		return err
 	}
-	r.rdbFile = []byte(rdbFile)
+	fmt.Printf("Loaded %d keys from the snapshot of the master\n", loaded)
 	fmt.Println("Successfully executed handshake. Master ID: " + strings.Split(psyncResponse, " ")[1])
 	return nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SLOWLOG subcommands
+const (
+	SLOWLOG_GET   = "GET"
+	SLOWLOG_LEN   = "LEN"
+	SLOWLOG_RESET = "RESET"
+)
+
+const (
+	SLOWLOG_DEFAULT_GET_COUNT = 10
+	// longer argument lists and arguments are truncated before being logged
+	SLOWLOG_ENTRY_MAX_ARGC   = 32
+	SLOWLOG_ENTRY_MAX_STRING = 128
+)
+
+// SlowLogEntry is a command which ran for longer than slowlog-log-slower-than
+type SlowLogEntry struct {
+	id         int64
+	timestamp  int64
+	duration   int64
+	args       []string
+	clientAddr string
+	clientName string
+}
+
+// SlowLog keeps the most recent slow commands, newest first, up to slowlog-max-len entries
+type SlowLog struct {
+	mu      sync.Mutex
+	entries []SlowLogEntry
+	nextId  int64
+}
+
+var ServerSlowLog = &SlowLog{}
+
+// Record logs the command if it ran for longer than the configured threshold. A negative threshold
+// disables the slow log, and a threshold of zero logs every command.
+func (s *SlowLog) Record(command string, args []string, duration time.Duration, clientAddr, clientName string) {
+	threshold := ServerConfig.GetInt(CONFIG_SLOWLOG_SLOWER_THAN)
+	if threshold < 0 || duration.Microseconds() < int64(threshold) {
+		return
+	}
+
+	entry := SlowLogEntry{
+		timestamp:  time.Now().Unix(),
+		duration:   duration.Microseconds(),
+		args:       truncateSlowLogArgs(append([]string{command}, args...)),
+		clientAddr: clientAddr,
+		clientName: clientName,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.id = s.nextId
+	s.nextId++
+	s.entries = append([]SlowLogEntry{entry}, s.entries...)
+	s.trim(ServerConfig.GetInt(CONFIG_SLOWLOG_MAX_LEN))
+}
+
+func (s *SlowLog) trim(maxLen int) {
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	if len(s.entries) > maxLen {
+		s.entries = s.entries[:maxLen]
+	}
+}
+
+// Trim drops the oldest entries beyond maxLen
+func (s *SlowLog) Trim(maxLen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trim(maxLen)
+}
+
+func (s *SlowLog) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *SlowLog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+}
+
+// Latest returns up to count of the newest entries, or every entry if count is negative
+func (s *SlowLog) Latest(count int) []SlowLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	return append([]SlowLogEntry{}, s.entries[:count]...)
+}
+
+func (e SlowLogEntry) toRespArray() string {
+	return ConcatIntoRespArray([]string{
+		ToRespInteger(int(e.id)),
+		ToRespInteger(int(e.timestamp)),
+		ToRespInteger(int(e.duration)),
+		ToRespBulkStringArray(e.args...),
+		ToRespBulkString(e.clientAddr),
+		ToRespBulkString(e.clientName),
+	})
+}
+
+func truncateSlowLogArgs(args []string) []string {
+	truncated := []string{}
+	for i, arg := range args {
+		if i == SLOWLOG_ENTRY_MAX_ARGC-1 && len(args) > SLOWLOG_ENTRY_MAX_ARGC {
+			truncated = append(truncated, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if len(arg) > SLOWLOG_ENTRY_MAX_STRING {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:SLOWLOG_ENTRY_MAX_STRING], len(arg)-SLOWLOG_ENTRY_MAX_STRING)
+		}
+		truncated = append(truncated, arg)
+	}
+	return truncated
+}
+
+// ExecuteSlowLog runs the SLOWLOG subcommands
+func ExecuteSlowLog(args []string) (string, error) {
+	switch strings.ToUpper(args[0]) {
+	case SLOWLOG_GET:
+		if len(args) > 2 {
+			return ToRespError(errors.New("wrong number of arguments for 'slowlog|get' command")), nil
+		}
+		count := SLOWLOG_DEFAULT_GET_COUNT
+		if len(args) == 2 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil || parsed < -1 {
+				return ToRespError(errors.New("count should be greater than or equal to -1")), nil
+			}
+			count = parsed
+		}
+		entries := []string{}
+		for _, entry := range ServerSlowLog.Latest(count) {
+			entries = append(entries, entry.toRespArray())
+		}
+		return ConcatIntoRespArray(entries), nil
+	case SLOWLOG_LEN:
+		return ToRespInteger(ServerSlowLog.Len()), nil
+	case SLOWLOG_RESET:
+		ServerSlowLog.Reset()
+		return ToRespSimpleString(OK), nil
+	default:
+		return ToRespError(fmt.Errorf("unknown subcommand '%s'. Try SLOWLOG HELP.", args[0])), nil
+	}
+}
+
+func applySlowLogMaxLen(value string) error {
+	maxLen, _ := strconv.Atoi(value)
+	ServerSlowLog.Trim(maxLen)
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "sync"
+
+// StreamWaiterRegistry holds the clients blocked by XREAD BLOCK, by the stream they wait on
+type StreamWaiterRegistry struct {
+	mu      sync.Mutex
+	waiters map[streamWaiterKey]map[chan struct{}]struct{}
+}
+
+type streamWaiterKey struct {
+	db  int
+	key string
+}
+
+var ServerStreamWaiters = &StreamWaiterRegistry{waiters: map[streamWaiterKey]map[chan struct{}]struct{}{}}
+
+// Add registers a waiter on the stream at key of the database at db. The channel returned receives
+// a value once an entry is added to the stream. It must be removed with Remove once the wait is over,
+// whatever ended it.
+func (s *StreamWaiterRegistry) Add(db int, key string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	waiter := make(chan struct{}, 1)
+	waiterKey := streamWaiterKey{db, key}
+	if s.waiters[waiterKey] == nil {
+		s.waiters[waiterKey] = map[chan struct{}]struct{}{}
+	}
+	s.waiters[waiterKey][waiter] = struct{}{}
+	return waiter
+}
+
+func (s *StreamWaiterRegistry) Remove(db int, key string, waiter chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	waiterKey := streamWaiterKey{db, key}
+	delete(s.waiters[waiterKey], waiter)
+	if len(s.waiters[waiterKey]) == 0 {
+		delete(s.waiters, waiterKey)
+	}
+}
+
+// Notify wakes up the waiters on the stream at key of the database at db. It never blocks: a waiter
+// which was already notified, and has not woken up yet, is skipped.
+func (s *StreamWaiterRegistry) Notify(db int, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for waiter := range s.waiters[streamWaiterKey{db, key}] {
+		select {
+		case waiter <- struct{}{}:
+		default:
+		}
+	}
+}
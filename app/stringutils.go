@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -27,3 +28,51 @@ func CapitalizeFirstCharacter(s string) string {
 	_, size := utf8.DecodeRuneInString(s)
 	return strings.ToUpper(s[:size]) + s[size:]
 }
+
+// BytesToHuman formats a number of bytes the way INFO does, such as 1.50M
+func BytesToHuman(bytes uint64) string {
+	value := float64(bytes)
+	switch {
+	case bytes < 1024:
+		return fmt.Sprintf("%dB", bytes)
+	case bytes < 1024*1024:
+		return fmt.Sprintf("%.2fK", value/1024)
+	case bytes < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", value/(1024*1024))
+	default:
+		return fmt.Sprintf("%.2fG", value/(1024*1024*1024))
+	}
+}
+
+// QuoteRepr quotes s so it can be printed on a single line, escaping quotes, backslashes and
+// non-printable bytes
+func QuoteRepr(s string) string {
+	quoted := strings.Builder{}
+	quoted.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		case '\a':
+			quoted.WriteString("\\a")
+		case '\b':
+			quoted.WriteString("\\b")
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&quoted, "\\x%02x", c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tls-auth-clients values
+const (
+	TLS_AUTH_CLIENTS_YES      = "yes"
+	TLS_AUTH_CLIENTS_NO       = "no"
+	TLS_AUTH_CLIENTS_OPTIONAL = "optional"
+)
+
+// loadTLSCertificate loads the certificate of tls-cert-file and tls-key-file, presented both to
+// clients and, on TLS replication links, to the master
+func loadTLSCertificate() (tls.Certificate, error) {
+	certFile, keyFile := ServerConfig.Get(CONFIG_TLS_CERT_FILE), ServerConfig.Get(CONFIG_TLS_KEY_FILE)
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("TLS requires tls-cert-file and tls-key-file to be set")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load the TLS certificate: %v", err)
+	}
+	return certificate, nil
+}
+
+// loadTLSCACertificates loads tls-ca-cert-file, or returns nil when it is not set so the system
+// certificates are used
+func loadTLSCACertificates() (*x509.CertPool, error) {
+	caFile := ServerConfig.Get(CONFIG_TLS_CA_CERT_FILE)
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS CA certificates: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig returns the configuration of the TLS listener. Clients must present a certificate
+// signed by tls-ca-cert-file according to tls-auth-clients.
+func ServerTLSConfig() (*tls.Config, error) {
+	certificate, err := loadTLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	caPool, err := loadTLSCACertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch ServerConfig.Get(CONFIG_TLS_AUTH_CLIENTS) {
+	case TLS_AUTH_CLIENTS_YES:
+		if caPool == nil {
+			return nil, errors.New("tls-auth-clients requires tls-ca-cert-file to be set")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case TLS_AUTH_CLIENTS_OPTIONAL:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		config.ClientAuth = tls.NoClientCert
+	}
+	return config, nil
+}
+
+// ReplicationTLSConfig returns the configuration of the TLS link to the master. The master
+// certificate is verified against tls-ca-cert-file, and the replica presents its own certificate.
+func ReplicationTLSConfig(masterHost string) (*tls.Config, error) {
+	caPool, err := loadTLSCACertificates()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs:    caPool,
+		ServerName: masterHost,
+		MinVersion: tls.VersionTLS12,
+	}
+	if ServerConfig.Get(CONFIG_TLS_CERT_FILE) != "" {
+		certificate, err := loadTLSCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
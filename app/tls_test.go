@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCertificates holds the files of a CA and of a certificate it signed for 127.0.0.1, which is
+// used both by the server and by the clients
+type testCertificates struct {
+	caFile, certFile, keyFile string
+	caPool                    *x509.CertPool
+	certificate               tls.Certificate
+}
+
+func generateTestCertificates(t *testing.T) testCertificates {
+	t.Helper()
+	dir := t.TempDir()
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := testCertificates{
+		caFile:   filepath.Join(dir, "ca.crt"),
+		certFile: filepath.Join(dir, "redis.crt"),
+		keyFile:  filepath.Join(dir, "redis.key"),
+		caPool:   x509.NewCertPool(),
+	}
+	for path, block := range map[string]*pem.Block{
+		certs.caFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		certs.certFile: {Type: "CERTIFICATE", Bytes: certDER},
+		certs.keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certs.caPool.AddCert(caCert)
+	certs.certificate, err = tls.LoadX509KeyPair(certs.certFile, certs.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+// setTestConfig sets configuration parameters for the duration of the test
+func setTestConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		previous := ServerConfig.Get(name)
+		if err := ServerConfig.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ServerConfig.Set(name, previous) })
+	}
+}
+
+// freeTestPort returns a local port nothing listens on
+func freeTestPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startTestTLSServer serves connections on a TLS port only, with the certificates and the client
+// authentication given
+func startTestTLSServer(t *testing.T, certs testCertificates, authClients string) int {
+	t.Helper()
+	ServerDatabases.FlushAll()
+	tlsPort := freeTestPort(t)
+	setTestConfig(t, map[string]string{
+		CONFIG_TLS_PORT:         strconv.Itoa(tlsPort),
+		CONFIG_TLS_CERT_FILE:    certs.certFile,
+		CONFIG_TLS_KEY_FILE:     certs.keyFile,
+		CONFIG_TLS_CA_CERT_FILE: certs.caFile,
+		CONFIG_TLS_AUTH_CLIENTS: authClients,
+	})
+
+	listener, err := Listen([]string{"127.0.0.1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTestConnections(t, listener)
+	return tlsPort
+}
+
+func dialTestTLSClient(t *testing.T, port int, config *tls.Config) *testClient {
+	t.Helper()
+	conn, err := tls.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn, bufio.NewReader(conn)}
+}
+
+func TestTLSClientWithoutCertificate(t *testing.T) {
+	certs := generateTestCertificates(t)
+	port := startTestTLSServer(t, certs, TLS_AUTH_CLIENTS_NO)
+
+	client := dialTestTLSClient(t, port, &tls.Config{RootCAs: certs.caPool})
+	if reply, err := client.do(SET, "tls", "plain"); err != nil || reply != "+OK" {
+		t.Fatalf("SET returned %q %v", reply, err)
+	}
+	if reply, err := client.do(GET, "tls"); err != nil || reply != "plain" {
+		t.Fatalf("GET returned %q %v", reply, err)
+	}
+}
+
+func TestTLSAuthClientsRequiresCertificate(t *testing.T) {
+	certs := generateTestCertificates(t)
+	port := startTestTLSServer(t, certs, TLS_AUTH_CLIENTS_YES)
+
+	// the server rejects the missing certificate after the client side of the handshake completed,
+	// so the failure shows on the first reply
+	anonymous := dialTestTLSClient(t, port, &tls.Config{RootCAs: certs.caPool})
+	if reply, err := anonymous.do(PING); err == nil {
+		t.Fatalf("client without a certificate got %q", reply)
+	}
+
+	client := dialTestTLSClient(t, port, &tls.Config{RootCAs: certs.caPool, Certificates: []tls.Certificate{certs.certificate}})
+	if reply, err := client.do(PING); err != nil || reply != "+PONG" {
+		t.Fatalf("PING returned %q %v", reply, err)
+	}
+}
+
+func TestTLSReplicationLink(t *testing.T) {
+	certs := generateTestCertificates(t)
+	port := startTestTLSServer(t, certs, TLS_AUTH_CLIENTS_YES)
+	setTestConfig(t, map[string]string{CONFIG_TLS_REPLICATION: CONFIG_YES})
+
+	replica, err := NewSlaveServer(0, "127.0.0.1 "+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := replica.dialMaster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("the link to the master is a %T, want a TLS connection", conn)
+	}
+
+	// the replica presents its certificate, which the master requires
+	master := &testClient{conn, bufio.NewReader(conn)}
+	if reply, err := master.do(PING); err != nil || reply != "+PONG" {
+		t.Fatalf("PING returned %q %v", reply, err)
+	}
+	if reply, err := master.do(REPLCONF, LISTENING_PORT_ARG, "6380"); err != nil || reply != "+OK" {
+		t.Fatalf("REPLCONF returned %q %v", reply, err)
+	}
+}
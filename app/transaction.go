@@ -1,17 +1,34 @@
 package main
 
 import (
+	"errors"
 	"net"
+	"strings"
+	"time"
 )
 
+// EXEC error code of transactions discarded because a command was rejected while queueing
+const EXECABORT = "EXECABORT"
+
+var ErrExecAbort = errors.New("Transaction discarded because of previous errors.")
+
 type Transaction struct {
 	Conn  net.Conn
 	Queue []CommandComponents
+	// aborted is set when a command is rejected while queueing, EXEC then discards the transaction
+	aborted bool
 }
 
 // New Transaction returns a new transaction with an empty queue and a nil channel
 func NewTransaction(conn net.Conn) Transaction {
-	return Transaction{conn, []CommandComponents{}}
+	return Transaction{Conn: conn, Queue: []CommandComponents{}}
+}
+
+// Abort makes the EXEC of the transaction in progress fail. It does nothing outside MULTI.
+func (t *Transaction) Abort() {
+	if t.Conn != nil {
+		t.aborted = true
+	}
 }
 
 // EnqueueCommand appens a new set of command components into the Transaction
@@ -19,26 +36,45 @@ func (t *Transaction) EnqueueCommand(cmp CommandComponents) {
 	t.Queue = append(t.Queue, cmp)
 }
 
-func (t *Transaction) ExecTransaction(s RedisServer) string {
+// TransactionWrite is a write run by EXEC, propagated along with the other writes of the transaction
+type TransactionWrite struct {
+	db    int
+	input string
+}
+
+// ExecTransaction runs the queued commands, returning their replies and the writes to propagate.
+// EXEC itself is left out of the slow log, every queued command is logged on its own instead.
+func (t *Transaction) ExecTransaction(s RedisServer, client *Client) (string, []TransactionWrite) {
 	results := []string{}
+	writes := []TransactionWrite{}
 	for _, cmp := range t.Queue {
-		command, args, _ := cmp.Command, cmp.Args, cmp.Input
+		command, args, input := cmp.Command, cmp.Args, cmp.Input
 		respCommand := RespCommands[command]
-		result, err := respCommand.Execute(args, s)
+		expireCommandKeys(s, client, cmp)
+		client.beginQueuedCommand()
+		start := time.Now()
+		result, err := respCommand.Execute(args, s, client)
+		ServerSlowLog.Record(command, redactArgs(command, args), time.Since(start), client.Address(), client.Name())
 
 		if err != nil {
 			results = append(results, err.Error())
-		} else {
-			results = append(results, result)
+			continue
+		}
+		results = append(results, result)
+		if respCommand.Type == WRITE && !strings.HasPrefix(result, ERROR_PREFIX) {
+			Stats.Dirty.Add(1)
+			if input := client.propagatedInput(input); input != "" {
+				writes = append(writes, TransactionWrite{client.DB(), input})
+			}
 		}
-
 	}
 
 	t.Reset()
-	return ConcatIntoRespArray(results)
+	return ConcatIntoRespArray(results), writes
 }
 
 func (t *Transaction) Reset() {
 	t.Conn = nil
 	t.Queue = []CommandComponents{}
+	t.aborted = false
 }
@@ -0,0 +1,243 @@
+// Command benchmark measures the throughput and latency of the server under concurrent clients.
+//
+// It either benchmarks a server which is already running:
+//
+//	go run ./benchmark -addr localhost:6379
+//
+// or builds a comparison of the execution modes by starting the given server binary once per mode:
+//
+//	go build -o /tmp/redis ./app && go run ./benchmark -server /tmp/redis
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Execution modes accepted by the server's -executor flag
+var executorModes = []string{"direct", "single"}
+
+type benchmarkResult struct {
+	requests  int
+	errors    int
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+func main() {
+	addr := flag.String("addr", "", "address of a running server to benchmark")
+	serverBinary := flag.String("server", "", "server binary to start once per execution mode")
+	port := flag.Int("port", 6399, "port used for the servers started with -server")
+	clients := flag.Int("clients", 50, "number of concurrent clients")
+	requests := flag.Int("requests", 2000, "number of requests sent by each client")
+	commands := flag.String("commands", "SET,GET,INCR,XADD", "comma separated commands sent by each client, in turn")
+
+	flag.Parse()
+
+	commandList := strings.Split(strings.ToUpper(*commands), ",")
+
+	if *serverBinary == "" {
+		if *addr == "" {
+			*addr = "localhost:6379"
+		}
+		result, err := runBenchmark(*addr, *clients, *requests, commandList)
+		if err != nil {
+			fmt.Println("Benchmark failed: ", err)
+			os.Exit(1)
+		}
+		printResult(*addr, result)
+		return
+	}
+
+	for _, mode := range executorModes {
+		result, err := benchmarkServer(*serverBinary, mode, *port, *clients, *requests, commandList)
+		if err != nil {
+			fmt.Printf("Benchmark of %s mode failed: %v\n", mode, err)
+			os.Exit(1)
+		}
+		printResult(mode, result)
+	}
+}
+
+func benchmarkServer(binary, mode string, port, clients, requests int, commands []string) (benchmarkResult, error) {
+	server := exec.Command(binary, "--port", strconv.Itoa(port), "--executor", mode)
+	if err := server.Start(); err != nil {
+		return benchmarkResult{}, err
+	}
+	defer func() {
+		server.Process.Kill()
+		server.Wait()
+	}()
+
+	addr := "localhost:" + strconv.Itoa(port)
+	if err := waitForServer(addr); err != nil {
+		return benchmarkResult{}, err
+	}
+
+	return runBenchmark(addr, clients, requests, commands)
+}
+
+func waitForServer(addr string) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return errors.New("server did not start listening on " + addr)
+}
+
+func runBenchmark(addr string, clients, requests int, commands []string) (benchmarkResult, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	result := benchmarkResult{}
+	errs := []error{}
+
+	start := time.Now()
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			latencies, failed, err := runClient(addr, client, requests, commands)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			result.requests += len(latencies)
+			result.errors += failed
+			result.latencies = append(result.latencies, latencies...)
+		}(i)
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+
+	if len(errs) > 0 {
+		return result, errs[0]
+	}
+	return result, nil
+}
+
+func runClient(addr string, client, requests int, commands []string) ([]time.Duration, int, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	latencies := make([]time.Duration, 0, requests)
+	failed := 0
+
+	for i := 0; i < requests; i++ {
+		command := commands[i%len(commands)]
+		request := buildRequest(command, client, i)
+
+		sent := time.Now()
+		if _, err := conn.Write([]byte(request)); err != nil {
+			return nil, 0, err
+		}
+		isError, err := readReply(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+		latencies = append(latencies, time.Since(sent))
+		if isError {
+			failed++
+		}
+	}
+
+	return latencies, failed, nil
+}
+
+func buildRequest(command string, client, i int) string {
+	key := "key:" + strconv.Itoa(i%100)
+	var args []string
+	switch command {
+	case "SET":
+		args = []string{"SET", key, "value-" + strconv.Itoa(client)}
+	case "GET":
+		args = []string{"GET", key}
+	case "INCR":
+		args = []string{"INCR", "counter"}
+	case "XADD":
+		args = []string{"XADD", "stream:" + strconv.Itoa(client), strconv.Itoa(i+1) + "-1", "field", "value"}
+	default:
+		args = []string{command}
+	}
+
+	request := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		request += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return request
+}
+
+// readReply reads a single RESP reply, returning whether it was an error reply
+func readReply(reader *bufio.Reader) (bool, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return false, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '-':
+		return true, nil
+	case '+', ':':
+		return false, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return false, err
+		}
+		_, err = reader.Discard(length + 2)
+		return false, err
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < length; i++ {
+			if _, err := readReply(reader); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+func printResult(name string, result benchmarkResult) {
+	slices.Sort(result.latencies)
+	percentile := func(p float64) time.Duration {
+		if len(result.latencies) == 0 {
+			return 0
+		}
+		return result.latencies[int(float64(len(result.latencies)-1)*p)]
+	}
+
+	throughput := float64(result.requests) / result.elapsed.Seconds()
+	fmt.Printf("%-8s requests=%d errors=%d elapsed=%s ops/sec=%.0f p50=%s p99=%s max=%s\n",
+		name, result.requests, result.errors, result.elapsed.Round(time.Millisecond), throughput,
+		percentile(0.50), percentile(0.99), percentile(1))
+}